@@ -0,0 +1,319 @@
+package kvclient
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var ErrInvalidBindTarget = errors.New("bind target must be a non-nil pointer to a struct")
+
+// Binding keeps the fields of a struct in sync with keys under a prefix.
+//
+// Fields are bound with a `kv:"name"` tag to the key prefix+name, untagged fields
+// (and fields tagged `kv:"-"`) are ignored. String fields are stored as-is, any
+// other type is stored as JSON.
+//
+// The struct is updated from pushes in a background goroutine, so it must only be
+// accessed while holding the binding lock (see RLock and Update).
+type Binding struct {
+	client *Client
+	prefix string
+	target reflect.Value
+	fields map[string]boundField // Keyed by full key
+
+	mu        sync.RWMutex
+	last      map[string]string   // Last known encoded value of every key
+	pending   map[string][]string // Values we wrote whose push hasn't come back yet, oldest first
+	callbacks map[string][]func(value interface{})
+	cbmu      sync.Mutex
+
+	chn     chan KeyValuePair
+	done    chan struct{}
+	closeMu sync.Mutex
+	closed  bool
+}
+
+type boundField struct {
+	name  string // Go field name
+	index int
+}
+
+// Bind binds the tagged fields of the struct pointed by target to keys under prefix,
+// loads their current values and starts following changes
+func (s *Client) Bind(prefix string, target interface{}) (*Binding, error) {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Pointer || ptr.IsNil() || ptr.Elem().Kind() != reflect.Struct {
+		return nil, ErrInvalidBindTarget
+	}
+
+	binding := &Binding{
+		client:    s,
+		prefix:    prefix,
+		target:    ptr.Elem(),
+		fields:    make(map[string]boundField),
+		last:      make(map[string]string),
+		pending:   make(map[string][]string),
+		callbacks: make(map[string][]func(value interface{})),
+		done:      make(chan struct{}),
+	}
+
+	structType := binding.target.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag, ok := field.Tag.Lookup("kv")
+		if !ok || tag == "-" || !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		binding.fields[prefix+name] = boundField{name: field.Name, index: i}
+	}
+
+	// Subscribe before loading so no change can be missed in between
+	var err error
+	binding.chn, err = s.SubscribePrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := s.GetByPrefix(prefix)
+	if err != nil {
+		_ = unsubscribeDraining(binding.chn, func() error { return s.UnsubscribePrefix(prefix, binding.chn) })
+		return nil, err
+	}
+
+	binding.mu.Lock()
+	for key, value := range values {
+		if err := binding.apply(key, value); err != nil {
+			binding.mu.Unlock()
+			_ = unsubscribeDraining(binding.chn, func() error { return s.UnsubscribePrefix(prefix, binding.chn) })
+			return nil, err
+		}
+	}
+	binding.mu.Unlock()
+
+	go binding.follow()
+
+	return binding, nil
+}
+
+// RLock locks the bound struct for reading
+func (b *Binding) RLock() {
+	b.mu.RLock()
+}
+
+// RUnlock undoes a single RLock call
+func (b *Binding) RUnlock() {
+	b.mu.RUnlock()
+}
+
+// Update calls fn with the bound struct locked for writing, then writes every
+// changed field back to kilovolt with a single SetKeys call
+func (b *Binding) Update(fn func()) error {
+	b.mu.Lock()
+	fn()
+	changed, previous, err := b.encode(false)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return b.write(changed, previous)
+}
+
+// Save writes all bound fields to kilovolt, whether they changed or not
+func (b *Binding) Save() error {
+	b.mu.Lock()
+	values, previous, err := b.encode(true)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return b.write(values, previous)
+}
+
+// encode encodes the bound fields (only the changed ones unless all is set) and
+// records them as the last known (and pending) values, returning the values they
+// replaced. The caller must hold the write lock.
+func (b *Binding) encode(all bool) (values map[string]string, previous map[string]string, err error) {
+	values = make(map[string]string)
+	for key, field := range b.fields {
+		encoded, err := encodeField(b.target.Field(field.index))
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not encode field %s: %w", field.name, err)
+		}
+		if last, ok := b.last[key]; all || !ok || last != encoded {
+			values[key] = encoded
+		}
+	}
+
+	previous = make(map[string]string, len(values))
+	for key, value := range values {
+		if last, ok := b.last[key]; ok {
+			previous[key] = last
+		}
+		b.last[key] = value
+		b.pending[key] = append(b.pending[key], value)
+	}
+	return values, previous, nil
+}
+
+// write sends encoded values without holding the lock, as follow needs it to keep
+// draining the pushes the write causes. If the write fails, the last known values
+// are restored (unless changed in the meantime) so that the fields are written again next time.
+func (b *Binding) write(values map[string]string, previous map[string]string) error {
+	if len(values) < 1 {
+		return nil
+	}
+
+	err := b.client.SetKeys(values)
+	if err == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, value := range values {
+		// No push will come back for this write
+		pending := b.pending[key]
+		for i := len(pending) - 1; i >= 0; i-- {
+			if pending[i] == value {
+				b.pending[key] = append(pending[:i:i], pending[i+1:]...)
+				break
+			}
+		}
+
+		if b.last[key] != value {
+			continue
+		}
+		if last, ok := previous[key]; ok {
+			b.last[key] = last
+		} else {
+			delete(b.last, key)
+		}
+	}
+	return err
+}
+
+// OnChange registers a function to be called with the new value of the field
+// (by Go field name) every time it's changed by someone else
+func (b *Binding) OnChange(field string, fn func(value interface{})) {
+	b.cbmu.Lock()
+	defer b.cbmu.Unlock()
+	b.callbacks[field] = append(b.callbacks[field], fn)
+}
+
+// Close stops following changes, the struct is left as it is
+func (b *Binding) Close() error {
+	b.closeMu.Lock()
+	defer b.closeMu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	// Keep draining pushes until the server stops sending them
+	err := b.client.UnsubscribePrefix(b.prefix, b.chn)
+	close(b.done)
+	return err
+}
+
+func (b *Binding) follow() {
+	for {
+		select {
+		case <-b.done:
+			return
+		case pair := <-b.chn:
+			field, ok := b.fields[pair.Key]
+			if !ok {
+				continue
+			}
+
+			b.mu.Lock()
+			if b.echo(pair.Key, pair.Value) {
+				b.mu.Unlock()
+				continue
+			}
+			if last, ok := b.last[pair.Key]; ok && last == pair.Value {
+				// Nothing changed
+				b.mu.Unlock()
+				continue
+			}
+			err := b.apply(pair.Key, pair.Value)
+			value := b.target.Field(field.index).Interface()
+			b.mu.Unlock()
+
+			if err != nil {
+				b.client.Logger.Error("could not decode bound field", "key", pair.Key, "error", err)
+				b.client.reportError(err)
+				continue
+			}
+
+			b.cbmu.Lock()
+			callbacks := b.callbacks[field.name]
+			b.cbmu.Unlock()
+			for _, fn := range callbacks {
+				fn(value)
+			}
+		}
+	}
+}
+
+// echo checks whether a push is one of our own writes coming back, in which case
+// it must not be applied if the field already holds a later value. Pushes come back
+// in order, so older pending writes are dropped with it. If it was the last pending
+// write and someone else changed the key in the meantime, the push still has to be
+// applied, as the key now holds our value again. The caller must hold the write lock.
+func (b *Binding) echo(key string, value string) bool {
+	pending := b.pending[key]
+	for i, written := range pending {
+		if written != value {
+			continue
+		}
+		if i < len(pending)-1 {
+			b.pending[key] = pending[i+1:]
+			return true
+		}
+		delete(b.pending, key)
+		return b.last[key] == value
+	}
+	return false
+}
+
+// apply decodes a value into its field, the caller must hold the write lock
+func (b *Binding) apply(key string, value string) error {
+	field, ok := b.fields[key]
+	if !ok {
+		return nil
+	}
+	b.last[key] = value
+
+	target := b.target.Field(field.index)
+	if value == "" {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+	if target.Kind() == reflect.String {
+		target.SetString(value)
+		return nil
+	}
+	if err := jsoniter.ConfigFastest.UnmarshalFromString(value, target.Addr().Interface()); err != nil {
+		return fmt.Errorf("could not decode %s into field %s: %w", key, field.name, err)
+	}
+	return nil
+}
+
+func encodeField(field reflect.Value) (string, error) {
+	if field.Kind() == reflect.String {
+		return field.String(), nil
+	}
+	return jsoniter.ConfigFastest.MarshalToString(field.Interface())
+}
@@ -0,0 +1,226 @@
+package kvclient
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type boundConfig struct {
+	Volume  float64  `kv:"volume"`
+	Channel string   `kv:"channel"`
+	Muted   bool     `kv:"muted"`
+	Tags    []string `kv:"tags"`
+	Ignored string
+}
+
+func TestBinding(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	if err = client.SetKeys(map[string]string{
+		"config/volume":  "0.5",
+		"config/channel": "strimertul",
+		"config/tags":    `["a","b"]`,
+	}); err != nil {
+		t.Fatal("error setting keys", err.Error())
+	}
+
+	var config boundConfig
+	binding, err := client.Bind("config/", &config)
+	if err != nil {
+		t.Fatal("error binding struct", err.Error())
+	}
+	defer binding.Close()
+
+	binding.RLock()
+	if config.Volume != 0.5 || config.Channel != "strimertul" || config.Muted || len(config.Tags) != 2 {
+		t.Fatal("struct not loaded correctly", config)
+	}
+	binding.RUnlock()
+
+	t.Run("Update", func(t *testing.T) {
+		if err := binding.Update(func() {
+			config.Muted = true
+			config.Ignored = "not saved"
+		}); err != nil {
+			t.Fatal("error updating struct", err.Error())
+		}
+		vals, err := client.GetByPrefix("config/")
+		if err != nil {
+			t.Fatal("error getting keys", err.Error())
+		}
+		if vals["config/muted"] != "true" {
+			t.Fatal("changed field not written", vals)
+		}
+		if _, ok := vals["config/Ignored"]; ok {
+			t.Fatal("untagged field written", vals)
+		}
+	})
+
+	t.Run("Push", func(t *testing.T) {
+		changes := make(chan interface{}, 1)
+		binding.OnChange("Volume", func(value interface{}) {
+			changes <- value
+		})
+
+		if err := client.SetKey("config/volume", "0.8"); err != nil {
+			t.Fatal("error modifying key", err.Error())
+		}
+		select {
+		case <-time.After(20 * time.Second):
+			t.Fatal("change took too long to arrive")
+		case value := <-changes:
+			if value.(float64) != 0.8 {
+				t.Fatal("wrong value in change callback", value)
+			}
+		}
+
+		binding.RLock()
+		defer binding.RUnlock()
+		if config.Volume != 0.8 {
+			t.Fatal("struct not updated from push", config.Volume)
+		}
+	})
+
+	t.Run("Quick updates", func(t *testing.T) {
+		changes := make(chan interface{}, 10)
+		binding.OnChange("Channel", func(value interface{}) {
+			changes <- value
+		})
+		muted := make(chan interface{}, 1)
+		release := make(chan struct{})
+		binding.OnChange("Muted", func(value interface{}) {
+			muted <- value
+			<-release
+		})
+
+		// Hold up the binding while updating, so our own writes come back after the
+		// field was updated again
+		if err := client.SetKey("config/muted", "false"); err != nil {
+			t.Fatal("error modifying key", err.Error())
+		}
+		<-muted
+		for _, channel := range []string{"first", "second"} {
+			if err := binding.Update(func() {
+				config.Channel = channel
+			}); err != nil {
+				t.Fatal("error updating struct", err.Error())
+			}
+		}
+		close(release)
+
+		// Pushes arrive in order, once this one is seen all the echoes have been too
+		if err := client.SetKey("config/muted", "true"); err != nil {
+			t.Fatal("error modifying key", err.Error())
+		}
+		select {
+		case <-time.After(20 * time.Second):
+			t.Fatal("change took too long to arrive")
+		case <-muted:
+		}
+
+		binding.RLock()
+		defer binding.RUnlock()
+		if config.Channel != "second" {
+			t.Fatal("field reset by an old write coming back", config.Channel)
+		}
+		if len(changes) > 0 {
+			t.Fatal("change callback called for our own writes", <-changes)
+		}
+	})
+}
+
+func TestBindingInvalidTarget(t *testing.T) {
+	client := &Client{}
+	var notAStruct int
+	for _, target := range []interface{}{nil, boundConfig{}, &notAStruct} {
+		if _, err := client.Bind("config/", target); !errors.Is(err, ErrInvalidBindTarget) {
+			t.Fatal("expected ErrInvalidBindTarget, got", err)
+		}
+	}
+}
+
+type manyFields struct {
+	F0  int `kv:"f0"`
+	F1  int `kv:"f1"`
+	F2  int `kv:"f2"`
+	F3  int `kv:"f3"`
+	F4  int `kv:"f4"`
+	F5  int `kv:"f5"`
+	F6  int `kv:"f6"`
+	F7  int `kv:"f7"`
+	F8  int `kv:"f8"`
+	F9  int `kv:"f9"`
+	F10 int `kv:"f10"`
+	F11 int `kv:"f11"`
+	F12 int `kv:"f12"`
+	F13 int `kv:"f13"`
+	F14 int `kv:"f14"`
+	F15 int `kv:"f15"`
+}
+
+func TestBindingManyFields(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	var fields manyFields
+	binding, err := client.Bind("many/", &fields)
+	if err != nil {
+		t.Fatal("error binding", err.Error())
+	}
+
+	// Every write pushes one change per field back to the binding, more than
+	// its subscription can buffer
+	done := make(chan error, 1)
+	go func() {
+		if err := binding.Save(); err != nil {
+			done <- err
+			return
+		}
+		done <- binding.Update(func() {
+			v := reflect.ValueOf(&fields).Elem()
+			for i := 0; i < v.NumField(); i++ {
+				v.Field(i).SetInt(int64(i + 1))
+			}
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal("error writing fields", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writing fields deadlocked")
+	}
+
+	if value, _ := client.GetKey("many/f15"); value != "16" {
+		t.Fatal("fields not written", value)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- binding.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal("error closing binding", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing binding deadlocked")
+	}
+}
@@ -0,0 +1,235 @@
+package kvclient
+
+import (
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// BusOptions configures an event bus
+type BusOptions struct {
+	// Prefix is prepended to topics to get the key events are written to
+	Prefix string
+
+	// PublisherID identifies events published by this bus, defaults to a random UUID
+	PublisherID string
+
+	// OnGap, if set, is called when a subscriber notices missing events
+	OnGap func(Gap)
+}
+
+// Bus publishes and subscribes to events, using a key per topic.
+//
+// Every event is written to the topic key wrapped in an Envelope. Keys only hold their
+// latest value, so events are not persisted: subscribers only receive events published
+// while they are subscribed, and miss those published while the connection is down.
+// Missed events are detected from sequence numbers and reported as gaps.
+//
+// A subscriber that stops reading its events stalls every push to the client, unless
+// ClientOptions.PushDeliveryTimeout is set: then pushes it can't keep up with are
+// dropped, and the events they carried are reported as gaps too.
+type Bus struct {
+	client  *Client
+	options BusOptions
+
+	mu  sync.Mutex
+	seq map[string]uint64 // Last sequence number published, by topic
+}
+
+// Envelope wraps an event with metadata about its publication
+type Envelope struct {
+	Seq       uint64              `json:"seq"`
+	Timestamp time.Time           `json:"timestamp"`
+	Publisher string              `json:"publisher"`
+	Data      jsoniter.RawMessage `json:"data"`
+}
+
+// Event is an event received from a Bus subscription
+type Event[T any] struct {
+	Topic     string
+	Seq       uint64    // Zero for events not published through a Bus
+	Timestamp time.Time // Zero for events not published through a Bus
+	Publisher string    // Empty for events not published through a Bus
+	Data      T
+
+	// Missed is how many events from the same publisher were missed right before this one
+	Missed uint64
+}
+
+// Gap describes events that a subscriber missed
+type Gap struct {
+	Topic     string
+	Publisher string
+	From, To  uint64 // Sequence numbers of the first and last missed events
+}
+
+// NewBus creates an event bus on top of the client
+func (s *Client) NewBus(options BusOptions) *Bus {
+	if options.PublisherID == "" {
+		options.PublisherID = UUIDGenerator()()
+	}
+	return &Bus{
+		client:  s,
+		options: options,
+		seq:     make(map[string]uint64),
+	}
+}
+
+// PublisherID returns the ID of events published by this bus
+func (b *Bus) PublisherID() string {
+	return b.options.PublisherID
+}
+
+// Publish encodes event as JSON and publishes it to topic
+func (b *Bus) Publish(topic string, event interface{}) error {
+	data, err := jsoniter.ConfigFastest.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// Hold the lock while writing so events on a topic are written in sequence order
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	seq := b.seq[topic] + 1
+	if err := b.client.SetJSON(b.options.Prefix+topic, Envelope{
+		Seq:       seq,
+		Timestamp: time.Now(),
+		Publisher: b.options.PublisherID,
+		Data:      data,
+	}); err != nil {
+		return err
+	}
+	b.seq[topic] = seq
+	return nil
+}
+
+// Subscription receives events for a topic
+type Subscription[T any] struct {
+	bus    *Bus
+	topic  string
+	key    string
+	chn    chan KeyValuePair
+	events chan Event[T]
+	done   chan struct{}
+	once   sync.Once
+
+	mu     sync.Mutex
+	last   map[string]uint64 // Last sequence number received, by publisher
+	missed uint64
+}
+
+// Subscribe starts receiving events published to topic, decoding them into T.
+// Values written to the topic key without going through a Bus are decoded as
+// events without metadata.
+func Subscribe[T any](bus *Bus, topic string) (*Subscription[T], error) {
+	sub := &Subscription[T]{
+		bus:    bus,
+		topic:  topic,
+		key:    bus.options.Prefix + topic,
+		events: make(chan Event[T], 16),
+		done:   make(chan struct{}),
+		last:   make(map[string]uint64),
+	}
+
+	var err error
+	sub.chn, err = bus.client.SubscribeKey(sub.key)
+	if err != nil {
+		return nil, err
+	}
+
+	go sub.receive()
+	return sub, nil
+}
+
+// Events returns the channel events are delivered to, it's closed when the subscription is closed
+func (s *Subscription[T]) Events() <-chan Event[T] {
+	return s.events
+}
+
+// Missed returns how many events were missed since the subscription started
+func (s *Subscription[T]) Missed() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.missed
+}
+
+// Close stops receiving events
+func (s *Subscription[T]) Close() error {
+	var err error
+	s.once.Do(func() {
+		// Stop receive first, it may be stuck on a consumer that stopped reading
+		close(s.done)
+
+		err = unsubscribeDraining(s.chn, func() error {
+			return s.bus.client.UnsubscribeKey(s.key, s.chn)
+		})
+	})
+	return err
+}
+
+func (s *Subscription[T]) receive() {
+	defer close(s.events)
+	for {
+		select {
+		case <-s.done:
+			return
+		case pair := <-s.chn:
+			if pair.Value == "" {
+				continue
+			}
+			event, err := s.decode(pair.Value)
+			if err != nil {
+				s.bus.client.Logger.Warn("could not decode event", "key", s.key, "error", err)
+				continue
+			}
+			select {
+			case s.events <- event:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+func (s *Subscription[T]) decode(value string) (Event[T], error) {
+	event := Event[T]{Topic: s.topic}
+
+	var envelope Envelope
+	if err := jsoniter.ConfigFastest.UnmarshalFromString(value, &envelope); err != nil || envelope.Publisher == "" || envelope.Seq == 0 {
+		// Not published through a bus
+		err = jsoniter.ConfigFastest.UnmarshalFromString(value, &event.Data)
+		return event, err
+	}
+
+	if err := jsoniter.ConfigFastest.Unmarshal(envelope.Data, &event.Data); err != nil {
+		return event, err
+	}
+	event.Seq = envelope.Seq
+	event.Timestamp = envelope.Timestamp
+	event.Publisher = envelope.Publisher
+
+	s.mu.Lock()
+	last, known := s.last[envelope.Publisher]
+	s.last[envelope.Publisher] = envelope.Seq
+	// Only count gaps going forward, a lower sequence number means the publisher restarted
+	if known && envelope.Seq > last+1 {
+		event.Missed = envelope.Seq - last - 1
+		s.missed += event.Missed
+	}
+	s.mu.Unlock()
+
+	if event.Missed > 0 {
+		s.bus.client.Logger.Warn("missed events", "key", s.key, "publisher", envelope.Publisher, "count", event.Missed)
+		if s.bus.options.OnGap != nil {
+			s.bus.options.OnGap(Gap{
+				Topic:     s.topic,
+				Publisher: envelope.Publisher,
+				From:      last + 1,
+				To:        envelope.Seq - 1,
+			})
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,169 @@
+package kvclient
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type chatMessage struct {
+	User string `json:"user"`
+	Text string `json:"text"`
+}
+
+func TestBus(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	gaps := make(chan Gap, 1)
+	bus := client.NewBus(BusOptions{
+		Prefix:      "twitch/ev/",
+		PublisherID: "bot",
+		OnGap: func(gap Gap) {
+			gaps <- gap
+		},
+	})
+
+	sub, err := Subscribe[chatMessage](bus, "chat-message")
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+	defer sub.Close()
+
+	next := func() Event[chatMessage] {
+		select {
+		case event := <-sub.Events():
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+		return Event[chatMessage]{}
+	}
+
+	t.Run("Publish", func(t *testing.T) {
+		for i, text := range []string{"hello", "world"} {
+			if err := bus.Publish("chat-message", chatMessage{User: "ash", Text: text}); err != nil {
+				t.Fatal("error publishing event", err.Error())
+			}
+			event := next()
+			if event.Data.Text != text || event.Data.User != "ash" {
+				t.Fatalf("unexpected event data: %+v", event.Data)
+			}
+			if event.Topic != "chat-message" || event.Publisher != "bot" || event.Seq != uint64(i+1) || event.Timestamp.IsZero() {
+				t.Fatalf("unexpected event metadata: %+v", event)
+			}
+			if event.Missed != 0 {
+				t.Fatal("unexpected gap", event.Missed)
+			}
+		}
+	})
+
+	t.Run("Without envelope", func(t *testing.T) {
+		if err := client.SetJSON("twitch/ev/chat-message", chatMessage{User: "legacy", Text: "raw"}); err != nil {
+			t.Fatal("error setting key", err.Error())
+		}
+		event := next()
+		if event.Data.User != "legacy" || event.Publisher != "" || event.Seq != 0 {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	})
+
+	t.Run("Gap", func(t *testing.T) {
+		// Pretend events 3 and 4 from the same publisher never made it
+		if err := client.SetJSON("twitch/ev/chat-message", Envelope{
+			Seq:       5,
+			Timestamp: time.Now(),
+			Publisher: "bot",
+			Data:      []byte(`{"user":"ash","text":"later"}`),
+		}); err != nil {
+			t.Fatal("error setting key", err.Error())
+		}
+		event := next()
+		if event.Missed != 2 || sub.Missed() != 2 {
+			t.Fatalf("gap not detected: %+v", event)
+		}
+		select {
+		case gap := <-gaps:
+			if gap.From != 3 || gap.To != 4 || gap.Publisher != "bot" || gap.Topic != "chat-message" {
+				t.Fatalf("unexpected gap: %+v", gap)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("gap was not reported")
+		}
+
+		// Other publishers have their own sequence
+		other := client.NewBus(BusOptions{Prefix: "twitch/ev/"})
+		if err := other.Publish("chat-message", chatMessage{Text: "first"}); err != nil {
+			t.Fatal("error publishing event", err.Error())
+		}
+		event = next()
+		if event.Publisher != other.PublisherID() || event.Seq != 1 || event.Missed != 0 {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		if err := sub.Close(); err != nil {
+			t.Fatal("error closing subscription", err.Error())
+		}
+		select {
+		case _, ok := <-sub.Events():
+			if ok {
+				t.Fatal("received event after closing")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("events channel was not closed")
+		}
+	})
+}
+
+func TestBusCloseStalledSubscriber(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	publisher, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	sub, err := Subscribe[int](client.NewBus(BusOptions{}), "levels")
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+
+	// Publish more events than the subscription buffers without reading any
+	bus := publisher.NewBus(BusOptions{})
+	for i := 0; i < 50; i++ {
+		if err := bus.Publish("levels", i); err != nil {
+			t.Fatal("error publishing", err.Error())
+		}
+	}
+	waitFor(t, "events to pile up", func() bool { return len(sub.Events()) == cap(sub.Events()) })
+
+	closed := make(chan error, 1)
+	go func() { closed <- sub.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal("error closing subscription", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing a stalled subscription deadlocked")
+	}
+
+	if _, err := client.GetKey("levels"); err != nil {
+		t.Fatal("client stuck after closing subscription", err.Error())
+	}
+}
@@ -7,25 +7,30 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
 	cmap "github.com/orcaman/concurrent-map"
 	kv "github.com/strimertul/kilovolt/v11"
-	"go.uber.org/zap"
 	"nhooyr.io/websocket"
 )
 
 var (
 	ErrSubscriptionNotFound = errors.New("subscription not found")
 	ErrEmptyKey             = errors.New("key empty or unset")
+	ErrMalformedFrame       = errors.New("malformed frame")
+	ErrConnectionRecycled   = errors.New("connection recycled before a response was received")
+	ErrRequestIDCollision   = errors.New("could not generate an unused request ID")
 )
 
+// How many times to ask the request ID generator for an ID that is not already in flight
+const maxRequestIDAttempts = 100
+
 type KeyValuePair struct {
 	Key   string
 	Value string
@@ -33,25 +38,114 @@ type KeyValuePair struct {
 
 type Client struct {
 	Endpoint string
-	Logger   *zap.Logger
+	Logger   Logger
 
 	headers    http.Header
 	ws         *websocket.Conn
 	mu         sync.Mutex         // Used to avoid concurrent writes to socket
-	requests   cmap.ConcurrentMap // map[string]chan<- string
-	keysubs    cmap.ConcurrentMap // map[string][]chan<- KeyValuePair
-	prefixsubs cmap.ConcurrentMap // map[string][]chan<- KeyValuePair
+	requests   cmap.ConcurrentMap // map[string]chan<- responseFrame
+	keysubs    cmap.ConcurrentMap // map[string][]*subscriber
+	prefixsubs cmap.ConcurrentMap // map[string][]*subscriber
+
+	nextID            RequestIDGenerator
+	invoke            Invoker     // Request interceptor chain
+	dispatch          PushHandler // Push interceptor chain
+	generation        uint64      // Incremented every time a new connection is opened
+	connected         int32
+	closed            int32
+	reconnectInterval time.Duration
+	queue             WriteQueue
+	flushMu           sync.Mutex // Held while replaying queued writes
+	metrics           Metrics
+	requestLogLevel   LogLevel
+	pushLogLevel      LogLevel
+	onError           func(error)
+	pushTimeout       time.Duration
+	maxMalformed      int
+	malformed         int32  // Consecutive malformed frames, reset on every valid one
+	malformedTotal    uint64 // Malformed frames since creation
+	pushes            uint64 // Pushes received since creation, tells which pushes arrived before a response
+	codec             ValueCodec
+	compression       websocket.CompressionMode
+	validators        validators
+	patternsubs       patternSubscriptions
+}
+
+// responseFrame is a response as received by the read loop
+type responseFrame struct {
+	message string
+	pushes  uint64 // Pushes received before this response
 }
 
+// pushMarkKey is a context key for a *uint64 that is set to how many pushes had been
+// received (see Client.pushes) when the response to the request arrived
+type pushMarkKey struct{}
+
 type ClientOptions struct {
 	Headers  http.Header
 	Password string
-	Logger   *zap.Logger
+
+	// Logger receives the client logs, defaults to NopLogger. See ZapLogger and SlogLogger for adapters.
+	Logger Logger
+
+	// RequestLogLevel and PushLogLevel are the levels used for logging every
+	// request sent and push received, which can be very noisy. Both default to LogDebug.
+	RequestLogLevel LogLevel
+	PushLogLevel    LogLevel
+
+	// Interceptors wrap every request, in order (the first one is the outermost)
+	Interceptors []Interceptor
+
+	// PushInterceptors wrap the delivery of every push to subscribers, in order
+	PushInterceptors []PushInterceptor
+
+	// Metrics receives measurements about requests, pushes and the connection
+	Metrics Metrics
+
+	// PushDeliveryTimeout is how long to wait for a subscriber with a full channel
+	// before dropping the push for it. Zero waits forever.
+	PushDeliveryTimeout time.Duration
+
+	// RequestIDGenerator is used to generate IDs for requests, defaults to CounterIDGenerator
+	RequestIDGenerator RequestIDGenerator
+
+	// OnError is called with non-fatal errors from the read loop, such as
+	// frames that could not be deserialized (wrapping ErrMalformedFrame) or
+	// pushed values that fail validation (*ValidationError)
+	OnError func(error)
+
+	// MaxMalformedFrames is how many consecutive malformed frames are tolerated
+	// before the connection is closed and reopened. Zero never recycles.
+	MaxMalformedFrames int
+
+	// ReconnectInterval is how long to wait between attempts to reconnect after
+	// the connection is lost. Zero disables reconnecting.
+	ReconnectInterval time.Duration
+
+	// OfflineQueue enables offline mode: writes made while the connection is down
+	// are added to the queue and replayed in order once it's back up. It's best
+	// used together with ReconnectInterval.
+	OfflineQueue WriteQueue
+
+	// ValueCodec, if set, encodes every value written and decodes every value read
+	// or pushed (see NewEncryptionCodec). Pushes that fail to decode are dropped and
+	// reported to OnError. Values are queued in OfflineQueue already encoded.
+	ValueCodec ValueCodec
+
+	// WebsocketCompression negotiates permessage-deflate compression of the
+	// connection, if the server supports it. Defaults to websocket.CompressionDisabled.
+	WebsocketCompression websocket.CompressionMode
 }
 
 func NewClient(endpoint string, options ClientOptions) (*Client, error) {
 	if options.Logger == nil {
-		options.Logger, _ = zap.NewProduction()
+		options.Logger = NopLogger()
+	}
+	if options.RequestIDGenerator == nil {
+		options.RequestIDGenerator = CounterIDGenerator()
+	}
+	if options.Metrics == nil {
+		options.Metrics = nopMetrics{}
 	}
 
 	client := &Client{
@@ -63,13 +157,47 @@ func NewClient(endpoint string, options ClientOptions) (*Client, error) {
 		requests:   cmap.New(), // make(map[string]chan<- string),
 		keysubs:    cmap.New(), // make(map[string][]chan<- string),
 		prefixsubs: cmap.New(), // make(map[string][]chan<- string),
+
+		nextID:          options.RequestIDGenerator,
+		metrics:         options.Metrics,
+		requestLogLevel: options.RequestLogLevel,
+		pushLogLevel:    options.PushLogLevel,
+		onError:         options.OnError,
+		pushTimeout:     options.PushDeliveryTimeout,
+		maxMalformed:    options.MaxMalformedFrames,
+
+		reconnectInterval: options.ReconnectInterval,
+		queue:             options.OfflineQueue,
+		codec:             options.ValueCodec,
+		compression:       options.WebsocketCompression,
 	}
 
+	interceptors := append([]Interceptor{client.metricsInterceptor}, options.Interceptors...)
+	interceptors = append(interceptors, client.validationInterceptor)
+	if options.ValueCodec != nil {
+		interceptors = append(interceptors, codecInterceptor(options.ValueCodec))
+	}
+	if options.OfflineQueue != nil {
+		interceptors = append(interceptors, client.offlineInterceptor)
+	}
+	if options.Password != "" {
+		interceptors = append(interceptors, client.authInterceptor(options.Password))
+	}
+	client.invoke = chainInterceptors(interceptors, client.roundTrip)
+	pushInterceptors := []PushInterceptor{client.metricsPushInterceptor}
+	if options.ValueCodec != nil {
+		pushInterceptors = append(pushInterceptors, client.codecPushInterceptor(options.ValueCodec))
+	}
+	pushInterceptors = append(pushInterceptors, client.validationPushInterceptor)
+	pushInterceptors = append(pushInterceptors, options.PushInterceptors...)
+	client.dispatch = chainPushInterceptors(pushInterceptors, client.deliver)
+
 	err := client.ConnectToWebsocket()
 	if err != nil {
 		return nil, err
 	}
 
+	// Authenticate right away so that a wrong password fails here
 	if options.Password != "" {
 		err = client.Authenticate(options.Password)
 		if err != nil {
@@ -81,7 +209,11 @@ func NewClient(endpoint string, options ClientOptions) (*Client, error) {
 }
 
 func (s *Client) Authenticate(password string) error {
-	res, err := s.makeRequest(kv.Request{
+	return s.authenticate(context.Background(), password, s.makeRequestContext)
+}
+
+func (s *Client) authenticate(ctx context.Context, password string, invoke Invoker) error {
+	res, err := invoke(ctx, kv.Request{
 		CmdName: kv.CmdAuthRequest,
 	})
 	if err != nil {
@@ -106,7 +238,7 @@ func (s *Client) Authenticate(password string) error {
 	hashBytes := hash.Sum(nil)
 
 	// Send auth challenge
-	_, err = s.makeRequest(kv.Request{
+	_, err = invoke(ctx, kv.Request{
 		CmdName: kv.CmdAuthChallenge,
 		Data: map[string]interface{}{
 			"hash": base64.StdEncoding.EncodeToString(hashBytes),
@@ -116,28 +248,56 @@ func (s *Client) Authenticate(password string) error {
 }
 
 func (s *Client) Close() error {
-	if s.ws != nil {
-		return s.ws.CloseNow()
+	atomic.StoreInt32(&s.closed, 1)
+	atomic.StoreInt32(&s.connected, 0)
+
+	s.mu.Lock()
+	ws := s.ws
+	s.mu.Unlock()
+	if ws != nil {
+		return ws.CloseNow()
 	}
 	return nil
 }
 
+// MalformedFrames returns how many received frames failed to deserialize since the client was created
+func (s *Client) MalformedFrames() uint64 {
+	return atomic.LoadUint64(&s.malformedTotal)
+}
+
 var (
 	newline = []byte{'\n'}
 	space   = []byte{' '}
 )
 
-func (s *Client) readNext() (websocket.MessageType, []byte, error) {
+func (s *Client) readNext(ws *websocket.Conn) (websocket.MessageType, []byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
-	return s.ws.Read(ctx)
+	return ws.Read(ctx)
 }
 
 func (s *Client) ConnectToWebsocket() error {
-	uri, err := url.Parse(s.Endpoint)
+	ws, err := s.dial()
 	if err != nil {
 		return err
 	}
+
+	s.mu.Lock()
+	s.ws = ws
+	atomic.AddUint64(&s.generation, 1)
+	s.mu.Unlock()
+	atomic.StoreInt32(&s.connected, 1)
+
+	go s.readLoop(ws)
+
+	return nil
+}
+
+func (s *Client) dial() (*websocket.Conn, error) {
+	uri, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
 	if uri.Scheme == "https" {
 		uri.Scheme = "wss"
 	} else {
@@ -147,77 +307,261 @@ func (s *Client) ConnectToWebsocket() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
-	s.ws, _, err = websocket.Dial(ctx, uri.String(), &websocket.DialOptions{
-		HTTPHeader: s.headers,
+	ws, _, err := websocket.Dial(ctx, uri.String(), &websocket.DialOptions{
+		HTTPHeader:      s.headers,
+		CompressionMode: s.compression,
 	})
+	return ws, err
+}
+
+func (s *Client) readLoop(ws *websocket.Conn) {
+	s.Logger.Debug("connected to ws, reading")
+	for {
+		mtype, message, err := s.readNext(ws)
+		if err != nil {
+			s.connectionLost(ws, err)
+			return
+		}
+		s.metrics.AddBytesReceived(len(message))
+		if mtype != websocket.MessageText {
+			continue
+		}
+
+		if !s.handleMessage(message) {
+			s.Logger.Warn("too many malformed frames, recycling connection", "threshold", s.maxMalformed)
+			if err := s.recycle(); err != nil {
+				s.Logger.Error("could not recycle connection", "error", err)
+				s.reportError(err)
+				_ = ws.CloseNow()
+				s.connectionLost(ws, err)
+			}
+			return
+		}
+	}
+}
+
+// connectionLost is called when a connection stops working. If it was not replaced
+// on purpose, pending requests are failed and, if enabled and the client was not
+// closed, reconnection starts.
+func (s *Client) connectionLost(ws *websocket.Conn, err error) {
+	s.mu.Lock()
+	current := s.ws == ws
+	s.mu.Unlock()
+	if !current {
+		return
+	}
+
+	atomic.StoreInt32(&s.connected, 0)
+	s.failRequests(s.requests.Keys())
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return
+	}
+	s.Logger.Error("websocket read error", "error", err)
+
+	if s.reconnectInterval > 0 {
+		go s.reconnectLoop()
+	}
+}
+
+func (s *Client) reconnectLoop() {
+	for atomic.LoadInt32(&s.closed) == 0 {
+		time.Sleep(s.reconnectInterval)
+		err := s.recycle()
+		if err == nil {
+			return
+		}
+		s.Logger.Warn("could not reconnect", "error", err)
+	}
+}
+
+// handleMessage processes every newline-separated frame in a websocket message.
+// It returns false if the malformed frame threshold has been reached and the
+// connection should be recycled.
+func (s *Client) handleMessage(message []byte) bool {
+	for _, msg := range strings.Split(string(message), "\n") {
+		// Batched messages can leave empty lines around, those are not errors
+		if strings.TrimSpace(msg) == "" {
+			continue
+		}
+
+		err := s.handleFrame(msg)
+		if err == nil {
+			atomic.StoreInt32(&s.malformed, 0)
+			continue
+		}
+
+		atomic.AddUint64(&s.malformedTotal, 1)
+		count := atomic.AddInt32(&s.malformed, 1)
+		s.Logger.Error("websocket deserialize error", "error", err)
+		s.reportError(fmt.Errorf("%w: %s", ErrMalformedFrame, err.Error()))
+
+		if s.maxMalformed > 0 && int(count) >= s.maxMalformed {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Client) handleFrame(msg string) error {
+	var response kv.Response
+	err := jsoniter.ConfigFastest.UnmarshalFromString(msg, &response)
 	if err != nil {
 		return err
 	}
 
-	go func() {
-		s.Logger.Debug("connected to ws, reading")
-		for {
-			mtype, message, err := s.readNext()
-			if err != nil {
-				s.Logger.Error("websocket read error", zap.Error(err))
-				return
-			}
-			if mtype != websocket.MessageText {
-				continue
-			}
+	// Check message
+	if response.RequestID != "" {
+		// We have a request ID, send byte chunk over to channel
+		if chn, ok := s.requests.Pop(response.RequestID); ok {
+			s.logAt(s.requestLogLevel, "recv response", "rid", response.RequestID)
+			chn.(chan responseFrame) <- responseFrame{message: msg, pushes: atomic.LoadUint64(&s.pushes)}
+		} else {
+			s.Logger.Error("received response for unknown RID", "rid", response.RequestID)
+		}
+		return nil
+	}
+
+	// Might be a push
+	switch response.CmdType {
+	case "push":
+		var push kv.Push
+		err = jsoniter.ConfigFastest.UnmarshalFromString(msg, &push)
+		if err != nil {
+			return err
+		}
+		s.logAt(s.pushLogLevel, "recv push", "key", push.Key)
+		atomic.AddUint64(&s.pushes, 1)
+		s.dispatch(KeyValuePair{push.Key, push.NewValue})
+	}
+	return nil
+}
 
-			submessages := strings.Split(string(message), "\n")
-			for _, msg := range submessages {
-				var response kv.Response
-				err = jsoniter.ConfigFastest.UnmarshalFromString(msg, &response)
-				if err != nil {
-					s.Logger.Error("websocket deserialize error", zap.Error(err))
-					return
-				}
-				// Check message
-				if response.RequestID != "" {
-					// We have a request ID, send byte chunk over to channel
-					if chn, ok := s.requests.Get(response.RequestID); ok {
-						s.Logger.Debug("recv response", zap.String("rid", response.RequestID))
-						chn.(chan string) <- msg
-						s.requests.Remove(response.RequestID)
-					} else {
-						s.Logger.Error("received response for unknown RID", zap.String("rid", response.RequestID))
-					}
-				} else {
-					// Might be a push
-					switch response.CmdType {
-					case "push":
-						var push kv.Push
-						err = jsoniter.ConfigFastest.UnmarshalFromString(msg, &push)
-						s.Logger.Debug("recv push", zap.String("key", push.Key))
-						if err != nil {
-							s.Logger.Error("websocket deserialize error", zap.Error(err))
-							continue
-						}
-						// Deliver to key subscriptions
-						if subs, ok := s.keysubs.Get(push.Key); ok {
-							for _, chann := range subs.([]chan KeyValuePair) {
-								chann <- KeyValuePair{push.Key, push.NewValue}
-							}
-						}
-						// Deliver to prefix subscritpions
-						for pair := range s.prefixsubs.IterBuffered() {
-							if strings.HasPrefix(push.Key, pair.Key) {
-								for _, chann := range pair.Val.([]chan KeyValuePair) {
-									chann <- KeyValuePair{push.Key, push.NewValue}
-								}
-							}
-						}
-					}
-				}
+// deliver is the last step of the push interceptor chain, it sends the push to all matching subscribers
+func (s *Client) deliver(push KeyValuePair) {
+	// Deliver to key subscriptions
+	if subs, ok := s.keysubs.Get(push.Key); ok {
+		for _, sub := range subs.([]*subscriber) {
+			s.deliverTo(sub, push)
+		}
+	}
+	// Deliver to prefix subscritpions
+	for pair := range s.prefixsubs.IterBuffered() {
+		if strings.HasPrefix(push.Key, pair.Key) {
+			for _, sub := range pair.Val.([]*subscriber) {
+				s.deliverTo(sub, push)
 			}
 		}
-	}()
+	}
+}
+
+func (s *Client) deliverTo(sub *subscriber, push KeyValuePair) {
+	if sub.filter != nil {
+		var ok bool
+		if push, ok = sub.filter(push); !ok {
+			return
+		}
+	}
+
+	chann := sub.ch
+	s.metrics.ObserveSubscriberBuffer(push.Key, len(chann), cap(chann))
+
+	if s.pushTimeout <= 0 {
+		chann <- push
+		return
+	}
+
+	timer := time.NewTimer(s.pushTimeout)
+	defer timer.Stop()
+	select {
+	case chann <- push:
+	case <-timer.C:
+		s.Logger.Warn("subscriber too slow, dropping push", "key", push.Key)
+		s.metrics.ObserveDroppedPush(push.Key)
+	}
+}
+
+func (s *Client) reportError(err error) {
+	if s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// recycle opens a new connection to replace the current one, restoring server-side
+// subscriptions (authentication is restored by the auth interceptor). Requests still
+// waiting on the old connection fail with ErrConnectionRecycled.
+func (s *Client) recycle() error {
+	ws, err := s.dial()
+	if err != nil {
+		return err
+	}
+
+	// Swap under the write lock, requests sent until now went to the old connection
+	s.mu.Lock()
+	old := s.ws
+	s.ws = ws
+	atomic.AddUint64(&s.generation, 1)
+	pending := s.requests.Keys()
+	s.mu.Unlock()
+
+	if old != nil {
+		_ = old.CloseNow()
+	}
+	s.failRequests(pending)
+	atomic.StoreInt32(&s.malformed, 0)
+	atomic.StoreInt32(&s.connected, 1)
+	s.metrics.ObserveReconnect()
+
+	go s.readLoop(ws)
+
+	for _, key := range s.keysubs.Keys() {
+		if data, ok := s.keysubs.Get(key); !ok || len(data.([]*subscriber)) < 1 {
+			continue
+		}
+		if _, err := s.makeRequest(kv.Request{
+			CmdName: kv.CmdSubscribeKey,
+			Data: map[string]interface{}{
+				"key": key,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	for _, prefix := range s.prefixsubs.Keys() {
+		if data, ok := s.prefixsubs.Get(prefix); !ok || len(data.([]*subscriber)) < 1 {
+			continue
+		}
+		if _, err := s.makeRequest(kv.Request{
+			CmdName: kv.CmdSubscribePrefix,
+			Data: map[string]interface{}{
+				"prefix": prefix,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Replay writes made while offline
+	if s.queue != nil {
+		go s.flushInBackground()
+	}
 
 	return nil
 }
 
+// failRequests makes the given pending requests fail with ErrConnectionRecycled
+func (s *Client) failRequests(rids []string) {
+	for _, rid := range rids {
+		if chn, ok := s.requests.Pop(rid); ok {
+			close(chn.(chan responseFrame))
+		}
+	}
+}
+
+// Connected returns whether the client currently has a working connection
+func (s *Client) Connected() bool {
+	return atomic.LoadInt32(&s.connected) == 1
+}
+
 func (s *Client) GetKey(key string) (string, error) {
 	resp, err := s.makeRequest(kv.Request{
 		CmdName: kv.CmdReadKey,
@@ -269,6 +613,8 @@ func (s *Client) GetByPrefix(prefix string) (map[string]string, error) {
 	return toReturn, nil
 }
 
+// GetJSON reads a key and decodes it as JSON into dst. If the value fails a
+// registered validator, it's still decoded but a *ValidationError is returned.
 func (s *Client) GetJSON(key string, dst interface{}) error {
 	resp, err := s.makeRequest(kv.Request{
 		CmdName: kv.CmdReadKey,
@@ -284,7 +630,10 @@ func (s *Client) GetJSON(key string, dst interface{}) error {
 		return ErrEmptyKey
 	}
 
-	return jsoniter.ConfigFastest.UnmarshalFromString(resp.Data.(string), dst)
+	if err := jsoniter.ConfigFastest.UnmarshalFromString(resp.Data.(string), dst); err != nil {
+		return err
+	}
+	return s.Validate(key, resp.Data.(string))
 }
 
 func (s *Client) SetKey(key string, data string) error {
@@ -349,54 +698,39 @@ func (s *Client) SetJSONs(data map[string]interface{}) error {
 	return err
 }
 
-func (s *Client) SubscribeKey(key string) (chan KeyValuePair, error) {
+// SubscribeKey subscribes to changes to a key, options can be used to limit how
+// often they are delivered (see Debounce, Throttle and Sample)
+func (s *Client) SubscribeKey(key string, options ...SubscribeOption) (chan KeyValuePair, error) {
 	chn := make(chan KeyValuePair, 10)
+	return chn, s.subscribeKey(key, newSubscriber(chn, nil, options))
+}
 
-	var subs []chan KeyValuePair
-	data, ok := s.keysubs.Get(key)
-	if ok {
-		subs = data.([]chan KeyValuePair)
-	}
-
-	needsAPISubscription := !ok || len(subs) < 1
-	s.keysubs.Set(key, append(subs, chn))
-
-	var err error
+func (s *Client) subscribeKey(key string, sub *subscriber) error {
 	// If this is the first time we subscribe to this key, ask server to push updates
-	if needsAPISubscription {
-		_, err = s.makeRequest(kv.Request{
+	if addSubscriber(s.keysubs, key, sub) {
+		_, err := s.makeRequest(kv.Request{
 			CmdName: kv.CmdSubscribeKey,
 			Data: map[string]interface{}{
 				"key": key,
 			},
 		})
+		return err
 	}
 
-	return chn, err
+	return nil
 }
 
 func (s *Client) UnsubscribeKey(key string, chn chan KeyValuePair) error {
-	data, ok := s.keysubs.Get(key)
-	if !ok {
+	if !s.keysubs.Has(key) {
 		return nil
 	}
-	chans := data.([]chan KeyValuePair)
-
-	found := false
-	for idx, sub := range chans {
-		if sub == chn {
-			chans = append(chans[:idx], chans[idx+1:]...)
-			s.keysubs.Set(key, chans)
-			found = true
-		}
-	}
-
+	found, empty := removeSubscriber(s.keysubs, key, chn)
 	if !found {
 		return ErrSubscriptionNotFound
 	}
 
 	// If we removed all subscribers, ask server to not push updates to us anymore
-	if len(chans) < 1 {
+	if empty {
 		_, err := s.makeRequest(kv.Request{
 			CmdName: kv.CmdUnsubscribeKey,
 			Data: map[string]interface{}{
@@ -409,54 +743,38 @@ func (s *Client) UnsubscribeKey(key string, chn chan KeyValuePair) error {
 	return nil
 }
 
-func (s *Client) SubscribePrefix(prefix string) (chan KeyValuePair, error) {
+// SubscribePrefix subscribes to changes to all keys starting with prefix, see SubscribeKey for options
+func (s *Client) SubscribePrefix(prefix string, options ...SubscribeOption) (chan KeyValuePair, error) {
 	chn := make(chan KeyValuePair, 10)
+	return chn, s.subscribePrefix(prefix, newSubscriber(chn, nil, options))
+}
 
-	var subs []chan KeyValuePair
-	data, ok := s.prefixsubs.Get(prefix)
-	if ok {
-		subs = data.([]chan KeyValuePair)
-	}
-
-	needsAPISubscription := !ok || len(subs) < 1
-	s.prefixsubs.Set(prefix, append(subs, chn))
-
-	var err error
-	// If this is the first time we subscribe to this key, ask server to push updates
-	if needsAPISubscription {
-		_, err = s.makeRequest(kv.Request{
+func (s *Client) subscribePrefix(prefix string, sub *subscriber) error {
+	// If this is the first time we subscribe to this prefix, ask server to push updates
+	if addSubscriber(s.prefixsubs, prefix, sub) {
+		_, err := s.makeRequest(kv.Request{
 			CmdName: kv.CmdSubscribePrefix,
 			Data: map[string]interface{}{
 				"prefix": prefix,
 			},
 		})
+		return err
 	}
 
-	return chn, err
+	return nil
 }
 
 func (s *Client) UnsubscribePrefix(prefix string, chn chan KeyValuePair) error {
-	data, ok := s.prefixsubs.Get(prefix)
-	if !ok {
+	if !s.prefixsubs.Has(prefix) {
 		return nil
 	}
-	chans := data.([]chan KeyValuePair)
-
-	found := false
-	for idx, sub := range chans {
-		if sub == chn {
-			chans = append(chans[:idx], chans[idx+1:]...)
-			s.prefixsubs.Set(prefix, chans)
-			found = true
-		}
-	}
-
+	found, empty := removeSubscriber(s.prefixsubs, prefix, chn)
 	if !found {
 		return ErrSubscriptionNotFound
 	}
 
 	// If we removed all subscribers, ask server to not push updates to us anymore
-	if len(chans) < 1 {
+	if empty {
 		_, err := s.makeRequest(kv.Request{
 			CmdName: kv.CmdUnsubscribePrefix,
 			Data: map[string]interface{}{
@@ -496,31 +814,74 @@ func (s *Client) InternalClientID() (int64, error) {
 	if err != nil {
 		return -1, err
 	}
-	return resp.Data.(int64), nil
+	// Numbers are decoded as float64 unless the decoder was told otherwise
+	switch id := resp.Data.(type) {
+	case float64:
+		return int64(id), nil
+	case int64:
+		return id, nil
+	case jsoniter.Number:
+		return id.Int64()
+	}
+	return -1, fmt.Errorf("unexpected client ID type %T", resp.Data)
 }
 
 func (s *Client) makeRequest(request kv.Request) (kv.Response, error) {
-	rid := ""
-	for {
-		rid = fmt.Sprintf("%x", rand.Int63())
-		if s.requests.Has(rid) {
-			continue
-		}
-		break
+	return s.makeRequestContext(context.Background(), request)
+}
+
+// makeRequestContext assigns a request ID (unless one is already set) and runs
+// the request through the interceptor chain
+func (s *Client) makeRequestContext(ctx context.Context, request kv.Request) (kv.Response, error) {
+	if request.RequestID == "" {
+		request.RequestID = s.nextID()
 	}
+	return s.invoke(ctx, request)
+}
 
-	responseChannel := make(chan string)
-	s.requests.Set(rid, responseChannel)
+// roundTrip is the last step of the interceptor chain, it sends the request
+// over the socket and waits for the matching response
+func (s *Client) roundTrip(ctx context.Context, request kv.Request) (kv.Response, error) {
+	// Buffered so the read loop never blocks on a request that gave up waiting
+	responseChannel := make(chan responseFrame, 1)
+
+	rid := request.RequestID
+	for attempt := 0; ; attempt++ {
+		if attempt >= maxRequestIDAttempts {
+			return kv.Response{}, ErrRequestIDCollision
+		}
+		if rid != "" && s.requests.SetIfAbsent(rid, responseChannel) {
+			break
+		}
+		rid = s.nextID()
+	}
+	s.metrics.AddInFlightRequests(1)
+	defer func() {
+		s.requests.Remove(rid)
+		s.metrics.AddInFlightRequests(-1)
+	}()
 
 	request.RequestID = rid
 	err := s.send(request)
-	s.Logger.Debug("sent request", zap.String("rid", request.RequestID), zap.String("cmd", request.CmdName))
+	s.logAt(s.requestLogLevel, "sent request", "rid", request.RequestID, "cmd", request.CmdName)
 	if err != nil {
 		return kv.Response{}, err
 	}
 
 	// Wait for reply
-	message := <-responseChannel
+	var message string
+	select {
+	case frame, ok := <-responseChannel:
+		if !ok {
+			return kv.Response{}, ErrConnectionRecycled
+		}
+		message = frame.message
+		if mark, ok := ctx.Value(pushMarkKey{}).(*uint64); ok {
+			*mark = frame.pushes
+		}
+	case <-ctx.Done():
+		return kv.Response{}, ctx.Err()
+	}
 
 	var response kv.Response
 	err = jsoniter.ConfigFastest.UnmarshalFromString(message, &response)
@@ -531,7 +892,7 @@ func (s *Client) makeRequest(request kv.Request) (kv.Response, error) {
 		if err != nil {
 			return kv.Response{}, err
 		}
-		return kv.Response{}, fmt.Errorf("%s: %s", resperror.Error, resperror.Details)
+		return kv.Response{}, &ServerError{Message: resperror.Error, Details: resperror.Details}
 	}
 
 	return response, err
@@ -548,8 +909,10 @@ func (s *Client) send(v interface{}) error {
 	if err != nil {
 		return err
 	}
-	err1 := jsoniter.ConfigFastest.NewEncoder(w).Encode(v)
+	counter := &countingWriter{w: w}
+	err1 := jsoniter.ConfigFastest.NewEncoder(counter).Encode(v)
 	err2 := w.Close()
+	s.metrics.AddBytesSent(counter.n)
 	if err1 != nil {
 		return err1
 	}
@@ -1,12 +1,19 @@
 package kvclient
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	jsoniter "github.com/json-iterator/go"
 	"go.uber.org/zap"
+	"nhooyr.io/websocket"
 
 	kv "github.com/strimertul/kilovolt/v11"
 )
@@ -16,7 +23,7 @@ func TestCommands(t *testing.T) {
 	server, _ := createInMemoryKV(t, log)
 
 	client, err := NewClient(server.URL, ClientOptions{
-		Logger: log,
+		Logger: ZapLogger(log),
 	})
 	if err != nil {
 		t.Fatal("error creating kv client", err.Error())
@@ -163,7 +170,7 @@ func TestKeySubscription(t *testing.T) {
 	server, _ := createInMemoryKV(t, log)
 
 	client, err := NewClient(server.URL, ClientOptions{
-		Logger: log,
+		Logger: ZapLogger(log),
 	})
 	if err != nil {
 		t.Fatal("error creating kv client", err.Error())
@@ -197,7 +204,7 @@ func TestPrefixSubscription(t *testing.T) {
 	server, _ := createInMemoryKV(t, log)
 
 	client, err := NewClient(server.URL, ClientOptions{
-		Logger: log,
+		Logger: ZapLogger(log),
 	})
 	if err != nil {
 		t.Fatal("error creating kv client", err.Error())
@@ -231,7 +238,7 @@ func TestKeyList(t *testing.T) {
 	server, _ := createInMemoryKV(t, log)
 
 	client, err := NewClient(server.URL, ClientOptions{
-		Logger: log,
+		Logger: ZapLogger(log),
 	})
 	if err != nil {
 		t.Fatal("error creating kv client", err.Error())
@@ -259,6 +266,32 @@ func TestKeyList(t *testing.T) {
 	}
 }
 
+func TestInternalClientID(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	first, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	second, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	firstID, err := first.InternalClientID()
+	if err != nil {
+		t.Fatal("error getting client ID", err.Error())
+	}
+	secondID, err := second.InternalClientID()
+	if err != nil {
+		t.Fatal("error getting client ID", err.Error())
+	}
+	if firstID == secondID {
+		t.Fatal("clients have the same ID", firstID)
+	}
+}
+
 func TestAuthentication(t *testing.T) {
 	log, _ := zap.NewDevelopment()
 
@@ -271,7 +304,7 @@ func TestAuthentication(t *testing.T) {
 
 	// Create client with password option
 	client, err := NewClient(server.URL, ClientOptions{
-		Logger:   log,
+		Logger:   ZapLogger(log),
 		Password: password,
 	})
 	if err != nil {
@@ -287,6 +320,117 @@ func TestAuthentication(t *testing.T) {
 	}
 }
 
+func TestMalformedFrames(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+
+	server := createScriptedServer(t, func(_ int, req kv.Request) string {
+		// Garbage, partial JSON and empty lines all come before the real response
+		return fmt.Sprintf("garbage\n{\"type\":\"resp\n\n   \n{\"type\":\"response\",\"ok\":true,\"request_id\":\"%s\",\"data\":\"value\"}\n", req.RequestID)
+	})
+
+	var mu sync.Mutex
+	var reported []error
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+		OnError: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, err)
+		},
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	for i := 0; i < 2; i++ {
+		val, err := client.GetKey("test")
+		if err != nil {
+			t.Fatal("error getting key", err.Error())
+		}
+		if val != "value" {
+			t.Fatalf("returned value is different than expected, expected=%s got=%s", "value", val)
+		}
+	}
+
+	if count := client.MalformedFrames(); count != 4 {
+		t.Fatalf("wrong number of malformed frames counted, expected=4 got=%d", count)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != 4 {
+		t.Fatalf("wrong number of errors reported, expected=4 got=%d", len(reported))
+	}
+	for _, err := range reported {
+		if !errors.Is(err, ErrMalformedFrame) {
+			t.Fatal("reported error is not a malformed frame error", err)
+		}
+	}
+}
+
+func TestMalformedFramesRecycle(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+
+	server := createScriptedServer(t, func(conn int, req kv.Request) string {
+		// First connection only ever replies with garbage
+		if conn == 1 {
+			return "garbage\n{\"partial\":\ngarbage"
+		}
+		return fmt.Sprintf("{\"type\":\"response\",\"ok\":true,\"request_id\":\"%s\",\"data\":\"value\"}", req.RequestID)
+	})
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger:             ZapLogger(log),
+		MaxMalformedFrames: 3,
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	if _, err = client.GetKey("test"); !errors.Is(err, ErrConnectionRecycled) {
+		t.Fatal("expected pending request to fail with ErrConnectionRecycled, got", err)
+	}
+
+	// The client should be usable again on the new connection
+	val, err := client.GetKey("test")
+	if err != nil {
+		t.Fatal("error getting key after recycle", err.Error())
+	}
+	if val != "value" {
+		t.Fatalf("returned value is different than expected, expected=%s got=%s", "value", val)
+	}
+}
+
+// createScriptedServer creates a websocket server that answers every request with
+// whatever reply returns. Connections are numbered starting from 1.
+func createScriptedServer(t *testing.T, reply func(conn int, req kv.Request) string) *httptest.Server {
+	var connections int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.CloseNow()
+
+		conn := int(atomic.AddInt32(&connections, 1))
+		for {
+			_, message, err := ws.Read(context.Background())
+			if err != nil {
+				return
+			}
+			var req kv.Request
+			if err := jsoniter.ConfigFastest.Unmarshal(message, &req); err != nil {
+				return
+			}
+			if err := ws.Write(context.Background(), websocket.MessageText, []byte(reply(conn, req))); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
 func createInMemoryKV(t *testing.T, log *zap.Logger) (*httptest.Server, *kv.Hub) {
 	// Create hub with in-mem DB
 	hub, err := kv.NewHub(kv.MakeBackend(), kv.HubOptions{}, log)
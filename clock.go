@@ -0,0 +1,29 @@
+package kvclient
+
+import "time"
+
+// Clock is a source of time, it can be replaced in tests
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d has elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a function call scheduled by a Clock
+type Timer interface {
+	// Stop cancels the call, returning false if it already happened or was stopped
+	Stop() bool
+}
+
+// SystemClock is the Clock backed by the time package
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
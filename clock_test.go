@@ -0,0 +1,97 @@
+package kvclient
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// fakeClock is a Clock that only moves forward when told to
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.stopped = true
+	return true
+}
+
+// Advance moves the clock forward, running every timer that comes due in order.
+// Timers run synchronously, so anything they do is done when Advance returns.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		sort.SliceStable(c.timers, func(i, j int) bool {
+			return c.timers[i].at.Before(c.timers[j].at)
+		})
+		var next *fakeTimer
+		for i, timer := range c.timers {
+			if timer.stopped {
+				continue
+			}
+			if timer.at.After(end) {
+				break
+			}
+			next = timer
+			c.timers = append(c.timers[:i:i], c.timers[i+1:]...)
+			break
+		}
+		if next == nil {
+			c.now = end
+			c.timers = pruneTimers(c.timers)
+			c.mu.Unlock()
+			return
+		}
+		next.stopped = true
+		c.now = next.at
+		c.mu.Unlock()
+
+		next.f()
+	}
+}
+
+func pruneTimers(timers []*fakeTimer) []*fakeTimer {
+	active := timers[:0]
+	for _, timer := range timers {
+		if !timer.stopped {
+			active = append(active, timer)
+		}
+	}
+	return active
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 
 	kvclient "github.com/strimertul/kilovolt-client-go/v11"
@@ -20,10 +21,12 @@ func check(err error) {
 func main() {
 	endpoint := flag.String("endpoint", "http://localhost:4338", "Address:port to connect to")
 	auth := flag.String("auth", "", "Optional Authorization string (for stulbe)")
-	command := flag.String("command", "", "Command to run (supported: kget/kset)")
-	key := flag.String("key", "", "Key to run command on")
+	command := flag.String("command", "", "Command to run (supported: kget/kset/validate)")
+	key := flag.String("key", "", "Key to run command on (prefix for validate)")
 	data := flag.String("data", "", "Optional data argument for commands that require it")
 	password := flag.String("password", "", "Optional password")
+	schema := flag.String("schema", "", "JSON Schema file to validate values against (for validate)")
+	pattern := flag.String("pattern", "", "Only validate keys matching this glob, or regex if prefixed with re: (for validate, defaults to every key)")
 	flag.Parse()
 
 	if *command == "" {
@@ -48,7 +51,59 @@ func main() {
 		fmt.Println(str)
 	case "kset":
 		check(client.SetKey(*key, *data))
+	case "validate":
+		check(validate(client, *key, *schema, *pattern))
 	default:
 		check(fmt.Errorf("unknown command \"%s\"", *command))
 	}
 }
+
+// validate checks every key under prefix against a schema, exiting with an error if any is invalid
+func validate(client *kvclient.Client, prefix string, schemaFile string, pattern string) error {
+	if schemaFile == "" {
+		return fmt.Errorf("must specify a -schema file")
+	}
+	data, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return err
+	}
+	schema, err := kvclient.ParseSchema(data)
+	if err != nil {
+		return err
+	}
+
+	var match *kvclient.Pattern
+	if pattern != "" {
+		if match, err = kvclient.ParsePattern(pattern); err != nil {
+			return err
+		}
+	}
+
+	values, err := client.GetByPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	invalid := 0
+	for _, key := range keys {
+		if values[key] == "" {
+			continue
+		}
+		if match != nil && !match.Match(key) {
+			continue
+		}
+		if err := schema.Validate(values[key]); err != nil {
+			invalid++
+			fmt.Printf("%s: %s\n", key, strings.ReplaceAll(err.Error(), "\n", "; "))
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid keys under %q", invalid, prefix)
+	}
+	return nil
+}
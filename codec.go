@@ -0,0 +1,174 @@
+package kvclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	kv "github.com/strimertul/kilovolt/v11"
+)
+
+// ValueCodec transforms values on their way to and from the server, e.g. to encrypt them.
+// Empty values mean "missing" and are never passed to a codec.
+type ValueCodec interface {
+	// Encode transforms a value before it's written to key
+	Encode(key string, value string) (string, error)
+	// Decode reverses Encode for a value read (or pushed) from key
+	Decode(key string, value string) (string, error)
+}
+
+// PrefixCodec uses a different codec depending on the key prefix. Keys are handled
+// by the codec with the longest matching prefix, keys with no match are left as-is.
+func PrefixCodec(codecs map[string]ValueCodec) ValueCodec {
+	return prefixCodec(codecs)
+}
+
+type prefixCodec map[string]ValueCodec
+
+func (p prefixCodec) codec(key string) ValueCodec {
+	var match string
+	var codec ValueCodec
+	for prefix, c := range p {
+		if strings.HasPrefix(key, prefix) && (codec == nil || len(prefix) > len(match)) {
+			match, codec = prefix, c
+		}
+	}
+	return codec
+}
+
+func (p prefixCodec) Encode(key string, value string) (string, error) {
+	if codec := p.codec(key); codec != nil {
+		return codec.Encode(key, value)
+	}
+	return value, nil
+}
+
+func (p prefixCodec) Decode(key string, value string) (string, error) {
+	if codec := p.codec(key); codec != nil {
+		return codec.Decode(key, value)
+	}
+	return value, nil
+}
+
+// ChainCodec applies several codecs in order when encoding, and in reverse order when decoding
+func ChainCodec(codecs ...ValueCodec) ValueCodec {
+	return chainCodec(codecs)
+}
+
+type chainCodec []ValueCodec
+
+func (c chainCodec) Encode(key string, value string) (string, error) {
+	var err error
+	for _, codec := range c {
+		if value, err = codec.Encode(key, value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+func (c chainCodec) Decode(key string, value string) (string, error) {
+	var err error
+	for i := len(c) - 1; i >= 0; i-- {
+		if value, err = c[i].Decode(key, value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+func encodeValue(codec ValueCodec, key string, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	encoded, err := codec.Encode(key, value)
+	if err != nil {
+		return "", fmt.Errorf("could not encode value for %s: %w", key, err)
+	}
+	return encoded, nil
+}
+
+func decodeValue(codec ValueCodec, key string, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	decoded, err := codec.Decode(key, value)
+	if err != nil {
+		return "", fmt.Errorf("could not decode value of %s: %w", key, err)
+	}
+	return decoded, nil
+}
+
+// codecInterceptor encodes written values and decodes read values
+func codecInterceptor(codec ValueCodec) Interceptor {
+	return func(ctx context.Context, request kv.Request, next Invoker) (kv.Response, error) {
+		// Queued writes were encoded before being queued
+		if ctx.Value(replayKey{}) != nil {
+			return next(ctx, request)
+		}
+
+		switch request.CmdName {
+		case kv.CmdWriteKey:
+			key, _ := request.Data["key"].(string)
+			value, _ := request.Data["data"].(string)
+			encoded, err := encodeValue(codec, key, value)
+			if err != nil {
+				return kv.Response{}, err
+			}
+			request.Data = map[string]interface{}{
+				"key":  key,
+				"data": encoded,
+			}
+		case kv.CmdWriteBulk:
+			data := make(map[string]interface{}, len(request.Data))
+			for key, v := range request.Data {
+				value, _ := v.(string)
+				encoded, err := encodeValue(codec, key, value)
+				if err != nil {
+					return kv.Response{}, err
+				}
+				data[key] = encoded
+			}
+			request.Data = data
+		}
+
+		response, err := next(ctx, request)
+		if err != nil {
+			return response, err
+		}
+
+		switch request.CmdName {
+		case kv.CmdReadKey:
+			key, _ := request.Data["key"].(string)
+			value, _ := response.Data.(string)
+			if response.Data, err = decodeValue(codec, key, value); err != nil {
+				return kv.Response{}, err
+			}
+		case kv.CmdReadBulk, kv.CmdReadPrefix:
+			values, _ := response.Data.(map[string]interface{})
+			decoded := make(map[string]interface{}, len(values))
+			for key, v := range values {
+				value, _ := v.(string)
+				if decoded[key], err = decodeValue(codec, key, value); err != nil {
+					return kv.Response{}, err
+				}
+			}
+			response.Data = decoded
+		}
+		return response, nil
+	}
+}
+
+// codecPushInterceptor decodes pushed values, pushes that can't be decoded are dropped
+func (s *Client) codecPushInterceptor(codec ValueCodec) PushInterceptor {
+	return func(push KeyValuePair, next PushHandler) {
+		value, err := decodeValue(codec, push.Key, push.Value)
+		if err != nil {
+			s.Logger.Error("dropping push that could not be decoded", "key", push.Key, "error", err)
+			s.reportError(err)
+			return
+		}
+		push.Value = value
+		next(push)
+	}
+}
@@ -0,0 +1,200 @@
+package kvclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionAlgorithm is an algorithm used to compress values
+type CompressionAlgorithm string
+
+const (
+	CompressionGzip   CompressionAlgorithm = "gzip"
+	CompressionZstd   CompressionAlgorithm = "zstd"
+	CompressionSnappy CompressionAlgorithm = "snappy"
+)
+
+// DefaultCompressionThreshold is the size (in bytes) under which values are not compressed
+const DefaultCompressionThreshold = 1024
+
+// DefaultMaxDecodedSize is the size (in bytes) a compressed value is allowed to expand to
+const DefaultMaxDecodedSize = 16 << 20
+
+// compressedPrefix starts every compressed value, followed by "<algorithm>:<base64 data>"
+const compressedPrefix = "cmp:v1:"
+
+var (
+	ErrUnknownCompression = errors.New("value is compressed with an unknown algorithm")
+	ErrDecodedTooLarge    = errors.New("decompressed value is too large")
+)
+
+// CompressionOptions configures a compression codec
+type CompressionOptions struct {
+	// Algorithm used to compress new values, defaults to CompressionGzip.
+	// Values compressed with any algorithm can be read regardless.
+	Algorithm CompressionAlgorithm
+
+	// Threshold is the size (in bytes) under which values are stored as they are,
+	// defaults to DefaultCompressionThreshold
+	Threshold int
+
+	// MaxDecodedSize is the size (in bytes) compressed values are allowed to expand to,
+	// larger values fail to decode. Defaults to DefaultMaxDecodedSize.
+	MaxDecodedSize int
+}
+
+// CompressionCodec is a ValueCodec that compresses large values. Compressed
+// values are prefixed with the algorithm used, values without the prefix are
+// read as they are. Values are only compressed if that makes them smaller.
+type CompressionCodec struct {
+	options CompressionOptions
+
+	zstdOnce    sync.Once
+	zstdDecoder *zstd.Decoder
+}
+
+// NewCompressionCodec creates a compression codec, use it as ClientOptions.ValueCodec.
+// To use it together with encryption, compress first: ChainCodec(compression, encryption).
+func NewCompressionCodec(options CompressionOptions) (*CompressionCodec, error) {
+	if options.Algorithm == "" {
+		options.Algorithm = CompressionGzip
+	}
+	if options.Threshold == 0 {
+		options.Threshold = DefaultCompressionThreshold
+	}
+	if options.MaxDecodedSize < 0 {
+		return nil, errors.New("max decoded size can't be negative")
+	}
+	if options.MaxDecodedSize == 0 {
+		options.MaxDecodedSize = DefaultMaxDecodedSize
+	}
+	if _, err := compress(options.Algorithm, nil); err != nil {
+		return nil, err
+	}
+	return &CompressionCodec{options: options}, nil
+}
+
+// Encode compresses value if it's larger than the threshold
+func (c *CompressionCodec) Encode(_ string, value string) (string, error) {
+	// Values that look compressed must always be wrapped, or they would be mangled when read
+	escape := strings.HasPrefix(value, compressedPrefix)
+	if len(value) < c.options.Threshold && !escape {
+		return value, nil
+	}
+
+	compressed, err := compress(c.options.Algorithm, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	encoded := compressedPrefix + string(c.options.Algorithm) + ":" + base64.RawStdEncoding.EncodeToString(compressed)
+	if len(encoded) >= len(value) && !escape {
+		return value, nil
+	}
+	return encoded, nil
+}
+
+// Decode decompresses value if it was compressed
+func (c *CompressionCodec) Decode(_ string, value string) (string, error) {
+	if !strings.HasPrefix(value, compressedPrefix) {
+		return value, nil
+	}
+
+	algorithm, encoded, ok := strings.Cut(strings.TrimPrefix(value, compressedPrefix), ":")
+	if !ok {
+		return "", errors.New("malformed compressed value")
+	}
+	compressed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("malformed compressed value: %w", err)
+	}
+	decompressed, err := c.decompress(CompressionAlgorithm(algorithm), compressed)
+	if err != nil {
+		return "", err
+	}
+	return string(decompressed), nil
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+)
+
+func initZstd() {
+	// Can't fail with these options
+	zstdEncoder, _ = zstd.NewWriter(nil)
+}
+
+func compress(algorithm CompressionAlgorithm, data []byte) ([]byte, error) {
+	switch algorithm {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		zstdOnce.Do(initZstd)
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, data), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCompression, algorithm)
+	}
+}
+
+// decompress expands data, failing with ErrDecodedTooLarge instead of going past MaxDecodedSize
+func (c *CompressionCodec) decompress(algorithm CompressionAlgorithm, data []byte) ([]byte, error) {
+	limit := c.options.MaxDecodedSize
+	switch algorithm {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		// Read one byte past the limit to tell a value of exactly that size from a larger one
+		decompressed, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(decompressed) > limit {
+			return nil, ErrDecodedTooLarge
+		}
+		return decompressed, nil
+	case CompressionZstd:
+		c.zstdOnce.Do(func() {
+			// Can't fail with these options
+			c.zstdDecoder, _ = zstd.NewReader(nil,
+				zstd.WithDecoderConcurrency(0),
+				zstd.WithDecoderMaxMemory(uint64(limit)))
+		})
+		decompressed, err := c.zstdDecoder.DecodeAll(data, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, ErrDecodedTooLarge
+		}
+		return decompressed, err
+	case CompressionSnappy:
+		size, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if size > limit {
+			return nil, ErrDecodedTooLarge
+		}
+		return snappy.Decode(nil, data)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCompression, algorithm)
+	}
+}
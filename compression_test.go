@@ -0,0 +1,132 @@
+package kvclient
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+)
+
+func TestCompressionCodec(t *testing.T) {
+	large := strings.Repeat(`{"user":"ash","message":"hello"},`, 100)
+
+	for _, algorithm := range []CompressionAlgorithm{CompressionGzip, CompressionZstd, CompressionSnappy} {
+		codec, err := NewCompressionCodec(CompressionOptions{Algorithm: algorithm})
+		if err != nil {
+			t.Fatal("error creating codec", err.Error())
+		}
+
+		encoded, err := codec.Encode("key", large)
+		if err != nil {
+			t.Fatal("error encoding", algorithm, err.Error())
+		}
+		if !strings.HasPrefix(encoded, "cmp:v1:"+string(algorithm)+":") || len(encoded) >= len(large) {
+			t.Fatal("value not compressed", algorithm, len(encoded))
+		}
+		if decoded, err := codec.Decode("key", encoded); err != nil || decoded != large {
+			t.Fatal("wrong round trip", algorithm, err)
+		}
+
+		// Values compressed with other algorithms can still be read
+		other, _ := NewCompressionCodec(CompressionOptions{Algorithm: CompressionGzip})
+		if decoded, err := other.Decode("key", encoded); err != nil || decoded != large {
+			t.Fatal("could not decode with a different algorithm", algorithm, err)
+		}
+	}
+
+	codec, _ := NewCompressionCodec(CompressionOptions{})
+	if encoded, _ := codec.Encode("key", "small"); encoded != "small" {
+		t.Fatal("value under the threshold was compressed", encoded)
+	}
+	// Small values that look compressed must survive the round trip
+	tricky := "cmp:v1:gzip:not really"
+	encoded, _ := codec.Encode("key", tricky)
+	if decoded, err := codec.Decode("key", encoded); err != nil || decoded != tricky {
+		t.Fatal("wrong round trip for value with prefix", decoded, err)
+	}
+
+	if _, err := NewCompressionCodec(CompressionOptions{Algorithm: "lzma"}); err == nil {
+		t.Fatal("expected error for unknown algorithm")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	compression, _ := NewCompressionCodec(CompressionOptions{Algorithm: CompressionZstd})
+	encryption := testEncryptionCodec(t, EncryptionKey{ID: "k1", Key: bytes.Repeat([]byte{1}, 32)})
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger:               ZapLogger(log),
+		ValueCodec:           ChainCodec(compression, encryption),
+		WebsocketCompression: websocket.CompressionContextTakeover,
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	raw, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	chn, err := client.SubscribeKey("history")
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+
+	history := make([]string, 200)
+	for i := range history {
+		history[i] = "the same chat message over and over"
+	}
+	if err := client.SetJSON("history", history); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+
+	var stored []string
+	if err := client.GetJSON("history", &stored); err != nil || len(stored) != len(history) {
+		t.Fatal("wrong value read back", len(stored), err)
+	}
+
+	// Compressed before being encrypted
+	value, _ := raw.GetKey("history")
+	decrypted, err := encryption.Decode("history", value)
+	if err != nil || !strings.HasPrefix(decrypted, "cmp:v1:zstd:") {
+		t.Fatal("value not compressed before encryption", decrypted, err)
+	}
+
+	select {
+	case push := <-chn:
+		if !strings.HasPrefix(push.Value, `["the same chat message`) {
+			t.Fatal("push not decoded", push.Value)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for push")
+	}
+}
+
+func TestCompressionMaxDecodedSize(t *testing.T) {
+	bomb := strings.Repeat("0", 1<<20)
+
+	for _, algorithm := range []CompressionAlgorithm{CompressionGzip, CompressionZstd, CompressionSnappy} {
+		writer, _ := NewCompressionCodec(CompressionOptions{Algorithm: algorithm})
+		encoded, err := writer.Encode("key", bomb)
+		if err != nil {
+			t.Fatal("error encoding", algorithm, err.Error())
+		}
+
+		reader, _ := NewCompressionCodec(CompressionOptions{MaxDecodedSize: 1024})
+		if _, err := reader.Decode("key", encoded); !errors.Is(err, ErrDecodedTooLarge) {
+			t.Fatal("expected ErrDecodedTooLarge", algorithm, err)
+		}
+
+		// Values of exactly the maximum size are fine
+		exact, _ := NewCompressionCodec(CompressionOptions{MaxDecodedSize: len(bomb)})
+		if decoded, err := exact.Decode("key", encoded); err != nil || decoded != bomb {
+			t.Fatal("value at the limit not decoded", algorithm, err)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+// Package config loads typed configuration from kilovolt and keeps it up to date.
+//
+// A Config always holds the last valid version of the configuration: updates that
+// fail to decode or to validate are rejected and reported, without replacing it.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	jsoniter "github.com/json-iterator/go"
+
+	kvclient "github.com/strimertul/kilovolt-client-go/v11"
+)
+
+var ErrClosed = errors.New("config closed")
+
+// Options configures how a configuration is loaded
+type Options[T any] struct {
+	// Validate is called on every new version, which is rejected if it returns an error
+	Validate func(T) error
+
+	// Default is used when there is no value stored yet, otherwise loading fails with kvclient.ErrEmptyKey
+	Default *T
+
+	// OnError is called when an update is rejected
+	OnError func(error)
+}
+
+// Config holds the latest valid version of a configuration stored in kilovolt
+type Config[T any] struct {
+	client  *kvclient.Client
+	options Options[T]
+	current atomic.Pointer[T]
+
+	// Exactly one of these is set
+	key    string
+	prefix string
+
+	fields map[string]interface{} // Current values for prefix configs, by field
+
+	mu        sync.Mutex
+	listeners []chan T
+	closed    bool
+
+	chn  chan kvclient.KeyValuePair
+	done chan struct{}
+}
+
+// Load loads a configuration stored as JSON in a single key and follows its updates
+func Load[T any](client *kvclient.Client, key string, options Options[T]) (*Config[T], error) {
+	cfg := &Config[T]{
+		client:  client,
+		options: options,
+		key:     key,
+		done:    make(chan struct{}),
+	}
+
+	// Subscribe before reading so no change can be missed in between
+	var err error
+	cfg.chn, err = client.SubscribeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := client.GetKey(key)
+	if err == nil {
+		err = cfg.load(value)
+	}
+	if err != nil {
+		_ = unsubscribeDraining(cfg.chn, func() error { return client.UnsubscribeKey(key, cfg.chn) })
+		return nil, err
+	}
+
+	go cfg.follow()
+
+	return cfg, nil
+}
+
+// LoadPrefix loads a configuration where every top-level field is stored in its
+// own key under prefix (eg. prefix+"volume" for the "volume" field) and follows
+// their updates. Values that are not valid JSON are used as strings.
+func LoadPrefix[T any](client *kvclient.Client, prefix string, options Options[T]) (*Config[T], error) {
+	cfg := &Config[T]{
+		client:  client,
+		options: options,
+		prefix:  prefix,
+		fields:  make(map[string]interface{}),
+		done:    make(chan struct{}),
+	}
+
+	var err error
+	cfg.chn, err = client.SubscribePrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := client.GetByPrefix(prefix)
+	if err == nil {
+		for key, value := range values {
+			cfg.setField(key, value)
+		}
+		err = cfg.loadFields()
+	}
+	if err != nil {
+		_ = unsubscribeDraining(cfg.chn, func() error { return client.UnsubscribePrefix(prefix, cfg.chn) })
+		return nil, err
+	}
+
+	go cfg.follow()
+
+	return cfg, nil
+}
+
+// Current returns the latest valid version of the configuration
+func (c *Config[T]) Current() T {
+	return *c.current.Load()
+}
+
+// Changes returns a channel receiving every new valid version of the configuration.
+// If the receiver falls behind, only the most recent version is kept.
+func (c *Config[T]) Changes() <-chan T {
+	chn := make(chan T, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		close(chn)
+		return chn
+	}
+	c.listeners = append(c.listeners, chn)
+	return chn
+}
+
+// Close stops following updates and closes all channels returned by Changes
+func (c *Config[T]) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	for _, chn := range c.listeners {
+		close(chn)
+	}
+	c.listeners = nil
+	c.mu.Unlock()
+
+	// follow keeps draining pushes until the server stops sending them
+	var err error
+	if c.prefix != "" {
+		err = c.client.UnsubscribePrefix(c.prefix, c.chn)
+	} else {
+		err = c.client.UnsubscribeKey(c.key, c.chn)
+	}
+	close(c.done)
+	return err
+}
+
+func (c *Config[T]) follow() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case pair := <-c.chn:
+			var err error
+			if c.prefix != "" {
+				err = c.updateField(pair.Key, pair.Value)
+			} else {
+				err = c.load(pair.Value)
+			}
+			if err != nil {
+				c.client.Logger.Warn("rejected configuration update", "key", pair.Key, "error", err)
+				if c.options.OnError != nil {
+					c.options.OnError(err)
+				}
+				continue
+			}
+			c.notify()
+		}
+	}
+}
+
+// load decodes, validates and swaps in a new version from a JSON value
+func (c *Config[T]) load(value string) error {
+	if value == "" {
+		if c.options.Default == nil {
+			return kvclient.ErrEmptyKey
+		}
+		cfg, err := c.defaultValue()
+		if err != nil {
+			return err
+		}
+		return c.swap(cfg)
+	}
+
+	var cfg T
+	if err := jsoniter.ConfigFastest.UnmarshalFromString(value, &cfg); err != nil {
+		return fmt.Errorf("could not decode configuration: %w", err)
+	}
+	return c.swap(cfg)
+}
+
+func (c *Config[T]) setField(key string, value string) {
+	field := strings.TrimPrefix(key, c.prefix)
+	if value == "" {
+		delete(c.fields, field)
+		return
+	}
+
+	var decoded interface{}
+	if err := jsoniter.ConfigFastest.UnmarshalFromString(value, &decoded); err != nil {
+		decoded = value
+	}
+	c.fields[field] = decoded
+}
+
+// updateField changes a single field and loads the result, putting the field back
+// as it was if the new version is rejected
+func (c *Config[T]) updateField(key string, value string) error {
+	field := strings.TrimPrefix(key, c.prefix)
+	previous, existed := c.fields[field]
+
+	c.setField(key, value)
+	err := c.loadFields()
+	if err != nil {
+		if existed {
+			c.fields[field] = previous
+		} else {
+			delete(c.fields, field)
+		}
+	}
+	return err
+}
+
+// loadFields builds, validates and swaps in a new version from the current field values
+func (c *Config[T]) loadFields() error {
+	var cfg T
+	if c.options.Default != nil {
+		var err error
+		if cfg, err = c.defaultValue(); err != nil {
+			return err
+		}
+	} else if len(c.fields) < 1 {
+		return kvclient.ErrEmptyKey
+	}
+
+	serialized, err := jsoniter.ConfigFastest.Marshal(c.fields)
+	if err != nil {
+		return err
+	}
+	if err := jsoniter.ConfigFastest.Unmarshal(serialized, &cfg); err != nil {
+		return fmt.Errorf("could not decode configuration: %w", err)
+	}
+	return c.swap(cfg)
+}
+
+// defaultValue returns a deep copy of the default configuration, so that maps and
+// slices in a version are never shared with the default or with other versions
+func (c *Config[T]) defaultValue() (T, error) {
+	var cfg T
+	serialized, err := jsoniter.ConfigFastest.Marshal(c.options.Default)
+	if err != nil {
+		return cfg, fmt.Errorf("could not copy default configuration: %w", err)
+	}
+	if err := jsoniter.ConfigFastest.Unmarshal(serialized, &cfg); err != nil {
+		return cfg, fmt.Errorf("could not copy default configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+func (c *Config[T]) swap(cfg T) error {
+	if c.options.Validate != nil {
+		if err := c.options.Validate(cfg); err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+	c.current.Store(&cfg)
+	return nil
+}
+
+func (c *Config[T]) notify() {
+	current := c.Current()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, chn := range c.listeners {
+		// Replace any version the listener hasn't read yet
+		select {
+		case <-chn:
+		default:
+		}
+		chn <- current
+	}
+}
+
+// unsubscribeDraining calls unsubscribe while discarding pushes delivered to chn, so
+// that a full channel can't block the client read loop before the server stops pushing
+func unsubscribeDraining(chn chan kvclient.KeyValuePair, unsubscribe func() error) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-chn:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return unsubscribe()
+}
@@ -0,0 +1,264 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kv "github.com/strimertul/kilovolt/v11"
+	"go.uber.org/zap"
+
+	kvclient "github.com/strimertul/kilovolt-client-go/v11"
+)
+
+type botConfig struct {
+	Prefix   string `json:"prefix"`
+	Cooldown int    `json:"cooldown"`
+}
+
+func validateBotConfig(cfg botConfig) error {
+	if cfg.Prefix == "" {
+		return errors.New("prefix is required")
+	}
+	return nil
+}
+
+func TestLoad(t *testing.T) {
+	client := createClient(t)
+
+	if err := client.SetJSON("bot/config", botConfig{Prefix: "!", Cooldown: 10}); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+
+	rejected := make(chan error, 1)
+	cfg, err := Load(client, "bot/config", Options[botConfig]{
+		Validate: validateBotConfig,
+		OnError: func(err error) {
+			rejected <- err
+		},
+	})
+	if err != nil {
+		t.Fatal("error loading config", err.Error())
+	}
+	defer cfg.Close()
+
+	if current := cfg.Current(); current.Prefix != "!" || current.Cooldown != 10 {
+		t.Fatal("config not loaded correctly", current)
+	}
+
+	changes := cfg.Changes()
+
+	// Valid update
+	if err = client.SetJSON("bot/config", botConfig{Prefix: "?", Cooldown: 5}); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	select {
+	case <-time.After(20 * time.Second):
+		t.Fatal("change took too long to arrive")
+	case current := <-changes:
+		if current.Prefix != "?" || current.Cooldown != 5 {
+			t.Fatal("wrong config received", current)
+		}
+	}
+
+	// Invalid update, the last valid version must be kept
+	if err = client.SetJSON("bot/config", botConfig{Cooldown: 1}); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	select {
+	case <-time.After(20 * time.Second):
+		t.Fatal("rejection took too long to arrive")
+	case <-rejected:
+	}
+	if current := cfg.Current(); current.Prefix != "?" || current.Cooldown != 5 {
+		t.Fatal("invalid config replaced the current one", current)
+	}
+
+	// Malformed update
+	if err = client.SetKey("bot/config", "{not json"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	select {
+	case <-time.After(20 * time.Second):
+		t.Fatal("rejection took too long to arrive")
+	case <-rejected:
+	}
+	if current := cfg.Current(); current.Prefix != "?" {
+		t.Fatal("malformed config replaced the current one", current)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	client := createClient(t)
+
+	if _, err := Load(client, "missing", Options[botConfig]{}); !errors.Is(err, kvclient.ErrEmptyKey) {
+		t.Fatal("expected ErrEmptyKey for missing config, got", err)
+	}
+
+	cfg, err := Load(client, "missing", Options[botConfig]{Default: &botConfig{Prefix: "!"}})
+	if err != nil {
+		t.Fatal("error loading config with default", err.Error())
+	}
+	defer cfg.Close()
+	if cfg.Current().Prefix != "!" {
+		t.Fatal("default not used", cfg.Current())
+	}
+
+	if err := client.SetJSON("invalid", botConfig{}); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	if _, err := Load(client, "invalid", Options[botConfig]{Validate: validateBotConfig}); err == nil {
+		t.Fatal("expected invalid config to fail loading")
+	}
+}
+
+func TestLoadPrefix(t *testing.T) {
+	client := createClient(t)
+
+	if err := client.SetKeys(map[string]string{
+		"bot/prefix":   "!",
+		"bot/cooldown": "10",
+	}); err != nil {
+		t.Fatal("error setting keys", err.Error())
+	}
+
+	cfg, err := LoadPrefix(client, "bot/", Options[botConfig]{Validate: validateBotConfig})
+	if err != nil {
+		t.Fatal("error loading config", err.Error())
+	}
+	defer cfg.Close()
+
+	if current := cfg.Current(); current.Prefix != "!" || current.Cooldown != 10 {
+		t.Fatal("config not loaded correctly", current)
+	}
+
+	changes := cfg.Changes()
+	if err = client.SetKey("bot/cooldown", "30"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	select {
+	case <-time.After(20 * time.Second):
+		t.Fatal("change took too long to arrive")
+	case current := <-changes:
+		if current.Prefix != "!" || current.Cooldown != 30 {
+			t.Fatal("wrong config received", current)
+		}
+	}
+}
+
+func TestLoadPrefixDefault(t *testing.T) {
+	client := createClient(t)
+
+	type aliasConfig struct {
+		Aliases map[string]string `json:"aliases"`
+	}
+	defaults := &aliasConfig{Aliases: map[string]string{"h": "help"}}
+
+	if err := client.SetKey("alias/aliases", `{"q":"quit"}`); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	cfg, err := LoadPrefix(client, "alias/", Options[aliasConfig]{Default: defaults})
+	if err != nil {
+		t.Fatal("error loading config", err.Error())
+	}
+	defer cfg.Close()
+
+	first := cfg.Current()
+	if len(first.Aliases) != 2 {
+		t.Fatal("config not loaded correctly", first)
+	}
+
+	changes := cfg.Changes()
+	if err = client.SetKey("alias/aliases", `{"x":"exit"}`); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	select {
+	case <-time.After(20 * time.Second):
+		t.Fatal("change took too long to arrive")
+	case current := <-changes:
+		if len(current.Aliases) != 2 || current.Aliases["x"] != "exit" {
+			t.Fatal("wrong config received", current)
+		}
+	}
+
+	// Neither the default nor older versions can be changed by later ones
+	if len(defaults.Aliases) != 1 {
+		t.Fatal("default was changed", defaults.Aliases)
+	}
+	if len(first.Aliases) != 2 || first.Aliases["q"] != "quit" {
+		t.Fatal("older version was changed", first.Aliases)
+	}
+}
+
+func TestCloseDuringBurst(t *testing.T) {
+	client := createClient(t)
+
+	// A slow validator lets pushes pile up in the subscription
+	cfg, err := LoadPrefix(client, "burst/", Options[map[string]int]{
+		Default: &map[string]int{},
+		Validate: func(map[string]int) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal("error loading config", err.Error())
+	}
+
+	// Every bulk write sends more pushes than the subscription buffers
+	written := make(chan error, 1)
+	go func() {
+		for i := 0; i < 20; i++ {
+			values := make(map[string]string)
+			for j := 0; j < 30; j++ {
+				values[fmt.Sprintf("burst/field%d", j)] = fmt.Sprint(i)
+			}
+			if err := client.SetKeys(values); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- cfg.Close() }()
+	for _, chn := range []chan error{closed, written} {
+		select {
+		case err := <-chn:
+			if err != nil {
+				t.Fatal("unexpected error", err.Error())
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("closing during a burst of updates deadlocked")
+		}
+	}
+}
+
+func createClient(t *testing.T) *kvclient.Client {
+	log, _ := zap.NewDevelopment()
+
+	// Create hub with in-mem DB
+	hub, err := kv.NewHub(kv.MakeBackend(), kv.HubOptions{}, log)
+	if err != nil {
+		t.Fatal("hub initialization failed", err.Error())
+	}
+	go hub.Run()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kv.ServeWs(hub, w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	client, err := kvclient.NewClient(ts.URL, kvclient.ClientOptions{
+		Logger: kvclient.ZapLogger(log),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	return client
+}
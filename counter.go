@@ -0,0 +1,280 @@
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kv "github.com/strimertul/kilovolt/v11"
+)
+
+var ErrCounterConflict = errors.New("counter kept changing, giving up")
+
+const (
+	// How many times Incr retries when other clients change the counter at the same time
+	maxCounterAttempts = 25
+	// Maximum wait between Incr attempts, multiplied by the attempt number
+	counterBackoff = 5 * time.Millisecond
+)
+
+// GetInt reads an integer key, returning ErrEmptyKey if it's empty
+func (s *Client) GetInt(key string) (int64, error) {
+	value, err := s.GetKey(key)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return 0, ErrEmptyKey
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// GetFloat reads a numeric key, returning ErrEmptyKey if it's empty
+func (s *Client) GetFloat(key string) (float64, error) {
+	value, err := s.GetKey(key)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return 0, ErrEmptyKey
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// Incr adds delta to an integer key (an empty key counts as 0) and returns the new value.
+//
+// Kilovolt has no atomic increment, so this is an optimistic read-modify-write: pushes
+// for the key are watched while it's being updated. If someone else writes to it before
+// our write, the increment starts over from their value; if our write turns out to have
+// overwritten someone else's, the increments it erased are added back.
+//
+// This relies on the server sending the pushes for a write before acknowledging it, as
+// kilovolt does. Every retry is an extra round trip, so for counters with many concurrent
+// writers a ShardedCounter is both faster and exact.
+func (s *Client) Incr(key string, delta int64) (int64, error) {
+	log := &pushLog{}
+	chn := make(chan KeyValuePair)
+	if err := s.subscribeKey(key, &subscriber{ch: chn, filter: func(pair KeyValuePair) (KeyValuePair, bool) {
+		// Runs in the read loop, right after the push was counted
+		log.add(atomic.LoadUint64(&s.pushes), pair.Value)
+		return pair, false
+	}}); err != nil {
+		return 0, err
+	}
+	defer s.UnsubscribeKey(key, chn)
+
+	// Pushes received up to this mark are already accounted for in value
+	var mark uint64
+	value, err := s.readMarked(key, &mark)
+	if err != nil {
+		return 0, err
+	}
+
+	for attempt := 0; attempt < maxCounterAttempts; attempt++ {
+		if attempt > 0 {
+			// Back off a random amount so that competing writers fall out of step
+			time.Sleep(time.Duration(rand.Int63n(int64(attempt) * int64(counterBackoff))))
+		}
+
+		// Someone changed the counter since we read it, build on their value. This
+		// doesn't need its own attempt: anything written from now on is repaired below.
+		if latest, ok := log.last(mark); ok {
+			value, mark = latest.value, latest.seq
+		}
+
+		base, err := parseCounter(key, value)
+		if err != nil {
+			return 0, err
+		}
+		next := strconv.FormatInt(base+delta, 10)
+
+		var written uint64
+		if err := s.writeMarked(key, next, &written); err != nil {
+			return 0, err
+		}
+
+		// Our push is the last one before the response, anything between the
+		// read and our push was a write that we just overwrote
+		pushes := log.between(mark, written)
+		if len(pushes) < 2 {
+			return base + delta, nil
+		}
+		own, overwritten := pushes[len(pushes)-1], pushes[len(pushes)-2]
+		lost, err := parseCounter(key, overwritten.value)
+		if err != nil {
+			return 0, err
+		}
+
+		// Add back the increments that were overwritten
+		delta = lost - base
+		value, mark = own.value, own.seq
+		if delta == 0 {
+			// What we overwrote had nothing new, the key holds our value
+			return parseCounter(key, own.value)
+		}
+	}
+	return 0, ErrCounterConflict
+}
+
+// Decr subtracts delta from an integer key, see Incr
+func (s *Client) Decr(key string, delta int64) (int64, error) {
+	return s.Incr(key, -delta)
+}
+
+// readMarked reads a key, setting mark to the number of pushes received before the response
+func (s *Client) readMarked(key string, mark *uint64) (string, error) {
+	resp, err := s.makeRequestContext(context.WithValue(context.Background(), pushMarkKey{}, mark), kv.Request{
+		CmdName: kv.CmdReadKey,
+		Data: map[string]interface{}{
+			"key": key,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Data.(string), nil
+}
+
+// writeMarked writes a key, setting mark to the number of pushes received before the response
+func (s *Client) writeMarked(key string, data string, mark *uint64) error {
+	_, err := s.makeRequestContext(context.WithValue(context.Background(), pushMarkKey{}, mark), kv.Request{
+		CmdName: kv.CmdWriteKey,
+		Data: map[string]interface{}{
+			"key":  key,
+			"data": data,
+		},
+	})
+	return err
+}
+
+func parseCounter(key, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("key %s is not an integer: %w", key, err)
+	}
+	return number, nil
+}
+
+// pushLog records the pushes for a key along with their position among all pushes received
+type pushLog struct {
+	mu      sync.Mutex
+	entries []loggedPush
+}
+
+type loggedPush struct {
+	seq   uint64
+	value string
+}
+
+func (l *pushLog) add(seq uint64, value string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, loggedPush{seq: seq, value: value})
+}
+
+// between returns the pushes after the from mark, up to and including the to mark
+func (l *pushLog) between(from, to uint64) []loggedPush {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var result []loggedPush
+	for _, entry := range l.entries {
+		if entry.seq > from && entry.seq <= to {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// last returns the most recent push after the from mark
+func (l *pushLog) last(from uint64) (loggedPush, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n := len(l.entries); n > 0 && l.entries[n-1].seq > from {
+		return l.entries[n-1], true
+	}
+	return loggedPush{}, false
+}
+
+// ShardedCounter is a counter split into one key per writer under a prefix, so
+// that writers never conflict. Reading the value sums all the shards.
+type ShardedCounter struct {
+	client *Client
+	prefix string
+	shard  string
+
+	mu     sync.Mutex
+	value  int64 // Value of our shard
+	loaded bool
+}
+
+// ShardedCounter returns the counter stored under prefix, writing to the shard with
+// the given ID. Shard IDs must be unique among writers and should be stable across
+// restarts (e.g. a hostname or replica name), an empty ID picks a random one.
+func (s *Client) ShardedCounter(prefix string, shardID string) *ShardedCounter {
+	if shardID == "" {
+		shardID = UUIDGenerator()()
+	}
+	return &ShardedCounter{
+		client: s,
+		prefix: prefix,
+		shard:  prefix + shardID,
+	}
+}
+
+// Incr adds delta to our shard and returns the new total
+func (c *ShardedCounter) Incr(delta int64) (int64, error) {
+	c.mu.Lock()
+	if !c.loaded {
+		value, err := c.client.GetKey(c.shard)
+		if err != nil {
+			c.mu.Unlock()
+			return 0, err
+		}
+		if c.value, err = parseCounter(c.shard, value); err != nil {
+			c.mu.Unlock()
+			return 0, err
+		}
+		c.loaded = true
+	}
+
+	next := c.value + delta
+	if err := c.client.SetKey(c.shard, strconv.FormatInt(next, 10)); err != nil {
+		c.mu.Unlock()
+		return 0, err
+	}
+	c.value = next
+	c.mu.Unlock()
+
+	return c.Value()
+}
+
+// Decr subtracts delta from our shard and returns the new total
+func (c *ShardedCounter) Decr(delta int64) (int64, error) {
+	return c.Incr(-delta)
+}
+
+// Value returns the sum of all shards
+func (c *ShardedCounter) Value() (int64, error) {
+	shards, err := c.client.GetByPrefix(c.prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for key, value := range shards {
+		number, err := parseCounter(key, value)
+		if err != nil {
+			return 0, err
+		}
+		total += number
+	}
+	return total, nil
+}
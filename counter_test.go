@@ -0,0 +1,159 @@
+package kvclient
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	kv "github.com/strimertul/kilovolt/v11"
+	"go.uber.org/zap"
+)
+
+func TestCounter(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	clients := make([]*Client, 4)
+	for i := range clients {
+		var err error
+		clients[i], err = NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+		if err != nil {
+			t.Fatal("error creating kv client", err.Error())
+		}
+	}
+	client := clients[0]
+
+	t.Run("Incr", func(t *testing.T) {
+		if _, err := client.GetInt("counter/simple"); !errors.Is(err, ErrEmptyKey) {
+			t.Fatal("expected ErrEmptyKey, got", err)
+		}
+		value, err := client.Incr("counter/simple", 5)
+		if err != nil || value != 5 {
+			t.Fatal("unexpected incr result", value, err)
+		}
+		value, err = client.Decr("counter/simple", 2)
+		if err != nil || value != 3 {
+			t.Fatal("unexpected decr result", value, err)
+		}
+		if value, _ := client.GetInt("counter/simple"); value != 3 {
+			t.Fatal("unexpected value", value)
+		}
+	})
+
+	t.Run("Not a number", func(t *testing.T) {
+		if err := client.SetKey("counter/text", "hello"); err != nil {
+			t.Fatal("error setting key", err.Error())
+		}
+		if _, err := client.Incr("counter/text", 1); err == nil {
+			t.Fatal("incremented a non-numeric key")
+		}
+	})
+
+	t.Run("GetFloat", func(t *testing.T) {
+		if err := client.SetKey("counter/float", "1.5"); err != nil {
+			t.Fatal("error setting key", err.Error())
+		}
+		if value, err := client.GetFloat("counter/float"); err != nil || value != 1.5 {
+			t.Fatal("unexpected value", value, err)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		// Different deltas per client, so lost or repeated increments show in the total
+		deltas := []int64{1, 100, 10000, 1000000}
+		const increments = 20
+
+		var wg sync.WaitGroup
+		errs := make(chan error, len(clients)*increments)
+		for i, c := range clients {
+			wg.Add(1)
+			go func(c *Client, delta int64) {
+				defer wg.Done()
+				for j := 0; j < increments; j++ {
+					if _, err := c.Incr("counter/concurrent", delta); err != nil {
+						errs <- err
+					}
+				}
+			}(c, deltas[i])
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal("error incrementing", err.Error())
+		}
+
+		value, err := client.GetInt("counter/concurrent")
+		if err != nil {
+			t.Fatal("error reading counter", err.Error())
+		}
+		if value != 1010101*increments {
+			t.Fatalf("increments were lost, expected=%d got=%d", 1010101*increments, value)
+		}
+	})
+
+	t.Run("Sharded", func(t *testing.T) {
+		const increments = 25
+
+		var wg sync.WaitGroup
+		errs := make(chan error, len(clients)*increments)
+		for i, c := range clients {
+			wg.Add(1)
+			go func(counter *ShardedCounter) {
+				defer wg.Done()
+				for j := 0; j < increments; j++ {
+					if _, err := counter.Incr(1); err != nil {
+						errs <- err
+					}
+				}
+			}(c.ShardedCounter("counter/sharded/", string(rune('a'+i))))
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal("error incrementing", err.Error())
+		}
+
+		counter := client.ShardedCounter("counter/sharded/", "a")
+		value, err := counter.Value()
+		if err != nil || value != int64(len(clients)*increments) {
+			t.Fatal("unexpected total", value, err)
+		}
+		// A new instance for an existing shard continues from its value
+		value, err = counter.Decr(int64(len(clients) * increments))
+		if err != nil || value != 0 {
+			t.Fatal("unexpected total", value, err)
+		}
+		if shard, _ := client.GetInt("counter/sharded/a"); shard != int64(increments-len(clients)*increments) {
+			t.Fatal("unexpected shard value", shard)
+		}
+	})
+}
+
+func TestCounterOverwriteWithoutChange(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+
+	// Others write 7 and then 5 again while our write is in flight, so the value we
+	// overwrite is the one we started from and nothing needs to be added back
+	server := createScriptedServer(t, func(_ int, req kv.Request) string {
+		response := fmt.Sprintf(`{"type":"response","ok":true,"request_id":%q,"data":"5"}`, req.RequestID)
+		if req.CmdName != kv.CmdWriteKey {
+			return response
+		}
+		push := `{"type":"push","key":"counter","new_value":%q}` + "\n"
+		return fmt.Sprintf(push, "7") + fmt.Sprintf(push, "5") + fmt.Sprintf(push, req.Data["data"]) + response
+	})
+
+	client, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	value, err := client.Incr("counter", 10)
+	if err != nil {
+		t.Fatal("error incrementing counter", err.Error())
+	}
+	if value != 15 {
+		t.Fatalf("wrong value returned, expected=15 got=%d", value)
+	}
+}
@@ -0,0 +1,394 @@
+package kvclient
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Snapshot is an in-memory set of keys and their values.
+// Keys with an empty value are considered missing.
+type Snapshot map[string]string
+
+// Snapshot reads all keys starting with prefix
+func (s *Client) Snapshot(prefix string) (Snapshot, error) {
+	keys, err := s.ListKeys(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(Snapshot)
+	for start := 0; start < len(keys); start += defaultSnapshotChunkSize {
+		values, err := s.GetKeys(keys[start:min(start+defaultSnapshotChunkSize, len(keys))])
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			if value != "" {
+				snapshot[key] = value
+			}
+		}
+	}
+	return snapshot, nil
+}
+
+// ReadSnapshot reads a snapshot written by Export
+func ReadSnapshot(r io.Reader) (Snapshot, *SnapshotHeader, error) {
+	var header *SnapshotHeader
+	snapshot := make(Snapshot)
+	err := readSnapshot(r, func(h *SnapshotHeader) {
+		header = h
+	}, func(entry SnapshotEntry) error {
+		if entry.Value != "" {
+			snapshot[entry.Key] = entry.Value
+		}
+		return nil
+	})
+	return snapshot, header, err
+}
+
+// ChangeKind is the type of change between two values
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeRemoved
+	ChangeModified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// KeyChange is a key that differs between two snapshots
+type KeyChange struct {
+	Key  string
+	Kind ChangeKind
+	Old  string // Empty for added keys
+	New  string // Empty for removed keys
+
+	// Fields lists what changed inside the value, only set for modified keys
+	// whose old and new values are both valid JSON
+	Fields []FieldChange
+}
+
+// FieldChange is a change inside a JSON value
+type FieldChange struct {
+	Path string // JSON Pointer (RFC 6901) to the changed field, "" for the whole value
+	Kind ChangeKind
+	Old  interface{}
+	New  interface{}
+}
+
+// Diff lists what needs to change for from to become to, sorted by key
+func Diff(from, to Snapshot) []KeyChange {
+	var changes []KeyChange
+	for key, old := range from {
+		value, ok := to[key]
+		switch {
+		case !ok || value == "":
+			if old != "" {
+				changes = append(changes, KeyChange{Key: key, Kind: ChangeRemoved, Old: old})
+			}
+		case old == "":
+			changes = append(changes, KeyChange{Key: key, Kind: ChangeAdded, New: value})
+		case old != value:
+			changes = append(changes, KeyChange{Key: key, Kind: ChangeModified, Old: old, New: value, Fields: diffValues(old, value)})
+		}
+	}
+	for key, value := range to {
+		if _, ok := from[key]; !ok && value != "" {
+			changes = append(changes, KeyChange{Key: key, Kind: ChangeAdded, New: value})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// Apply applies changes (as returned by Diff) to a snapshot, returning the new snapshot
+func (s Snapshot) Apply(changes []KeyChange) Snapshot {
+	result := make(Snapshot, len(s))
+	for key, value := range s {
+		result[key] = value
+	}
+	for _, change := range changes {
+		if change.Kind == ChangeRemoved {
+			delete(result, change.Key)
+		} else {
+			result[change.Key] = change.New
+		}
+	}
+	return result
+}
+
+// ApplyDiff writes only the keys in changes, removed keys are set to an empty value
+func (s *Client) ApplyDiff(changes []KeyChange) error {
+	if len(changes) < 1 {
+		return nil
+	}
+
+	delta := make(map[string]string, len(changes))
+	for _, change := range changes {
+		delta[change.Key] = change.New
+	}
+
+	keys := make([]string, 0, len(delta))
+	for key := range delta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for start := 0; start < len(keys); start += defaultSnapshotChunkSize {
+		chunk := make(map[string]string)
+		for _, key := range keys[start:min(start+defaultSnapshotChunkSize, len(keys))] {
+			chunk[key] = delta[key]
+		}
+		if err := s.SetKeys(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeConflict is a key (or a field inside a JSON value) that was changed
+// in different ways on both sides of a merge
+type MergeConflict struct {
+	Key    string
+	Path   string // JSON Pointer to the conflicting field, "" if the whole value conflicts
+	Base   string
+	Ours   string
+	Theirs string
+}
+
+// Merge does a three-way merge of two snapshots that were both derived from base.
+// Changes made on only one side are kept; when both sides changed a key differently
+// and both values are JSON objects, non-overlapping field changes are merged.
+// Anything else is reported as a conflict and the merged snapshot keeps our value.
+func Merge(base, ours, theirs Snapshot) (Snapshot, []MergeConflict) {
+	merged := make(Snapshot)
+	var conflicts []MergeConflict
+
+	keys := make(map[string]struct{})
+	for _, snapshot := range []Snapshot{base, ours, theirs} {
+		for key := range snapshot {
+			keys[key] = struct{}{}
+		}
+	}
+
+	for key := range keys {
+		b, o, t := base[key], ours[key], theirs[key]
+
+		var value string
+		switch {
+		case o == t, t == b:
+			value = o
+		case o == b:
+			value = t
+		default:
+			var fieldConflicts []string
+			var ok bool
+			value, fieldConflicts, ok = mergeJSON(b, o, t)
+			if !ok {
+				value = o
+				fieldConflicts = []string{""}
+			}
+			for _, path := range fieldConflicts {
+				conflicts = append(conflicts, MergeConflict{Key: key, Path: path, Base: b, Ours: o, Theirs: t})
+			}
+		}
+
+		if value != "" {
+			merged[key] = value
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Key == conflicts[j].Key {
+			return conflicts[i].Path < conflicts[j].Path
+		}
+		return conflicts[i].Key < conflicts[j].Key
+	})
+	return merged, conflicts
+}
+
+func parseJSON(value string) (interface{}, bool) {
+	var parsed interface{}
+	if value == "" || jsoniter.ConfigFastest.UnmarshalFromString(value, &parsed) != nil {
+		return nil, false
+	}
+	return parsed, true
+}
+
+func diffValues(old, value string) []FieldChange {
+	a, ok := parseJSON(old)
+	if !ok {
+		return nil
+	}
+	b, ok := parseJSON(value)
+	if !ok {
+		return nil
+	}
+	return diffJSON("", a, b, nil)
+}
+
+func diffJSON(path string, a, b interface{}, changes []FieldChange) []FieldChange {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		fields := make([]string, 0, len(av)+len(bv))
+		for field := range av {
+			fields = append(fields, field)
+		}
+		for field := range bv {
+			if _, ok := av[field]; !ok {
+				fields = append(fields, field)
+			}
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			fieldPath := path + "/" + escapePointer(field)
+			old, inA := av[field]
+			value, inB := bv[field]
+			switch {
+			case !inA:
+				changes = append(changes, FieldChange{Path: fieldPath, Kind: ChangeAdded, New: value})
+			case !inB:
+				changes = append(changes, FieldChange{Path: fieldPath, Kind: ChangeRemoved, Old: old})
+			default:
+				changes = diffJSON(fieldPath, old, value, changes)
+			}
+		}
+		return changes
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(av), len(bv)); i++ {
+			itemPath := path + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(av):
+				changes = append(changes, FieldChange{Path: itemPath, Kind: ChangeAdded, New: bv[i]})
+			case i >= len(bv):
+				changes = append(changes, FieldChange{Path: itemPath, Kind: ChangeRemoved, Old: av[i]})
+			default:
+				changes = diffJSON(itemPath, av[i], bv[i], changes)
+			}
+		}
+		return changes
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		changes = append(changes, FieldChange{Path: path, Kind: ChangeModified, Old: a, New: b})
+	}
+	return changes
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func escapePointer(field string) string {
+	return pointerEscaper.Replace(field)
+}
+
+// mergeJSON merges the fields of two JSON objects derived from the same base object.
+// It returns false if the values are not all JSON objects (a missing base counts as empty).
+func mergeJSON(base, ours, theirs string) (string, []string, bool) {
+	o, ok := parseJSON(ours)
+	if !ok {
+		return "", nil, false
+	}
+	t, ok := parseJSON(theirs)
+	if !ok {
+		return "", nil, false
+	}
+	var b interface{} = map[string]interface{}{}
+	if base != "" {
+		if b, ok = parseJSON(base); !ok {
+			return "", nil, false
+		}
+	}
+
+	bm, bOk := b.(map[string]interface{})
+	om, oOk := o.(map[string]interface{})
+	tm, tOk := t.(map[string]interface{})
+	if !bOk || !oOk || !tOk {
+		return "", nil, false
+	}
+
+	merged, conflicts := mergeObjects("", bm, om, tm)
+	result, err := jsoniter.ConfigCompatibleWithStandardLibrary.MarshalToString(merged)
+	if err != nil {
+		return "", nil, false
+	}
+	return result, conflicts, true
+}
+
+func mergeObjects(path string, base, ours, theirs map[string]interface{}) (map[string]interface{}, []string) {
+	merged := make(map[string]interface{})
+	var conflicts []string
+
+	fields := make(map[string]struct{})
+	for _, object := range []map[string]interface{}{base, ours, theirs} {
+		for field := range object {
+			fields[field] = struct{}{}
+		}
+	}
+
+	for field := range fields {
+		b, inB := base[field]
+		o, inO := ours[field]
+		t, inT := theirs[field]
+		sameOT := inO == inT && reflect.DeepEqual(o, t)
+		sameTB := inT == inB && reflect.DeepEqual(t, b)
+		sameOB := inO == inB && reflect.DeepEqual(o, b)
+
+		switch {
+		case sameOT, sameTB:
+			if inO {
+				merged[field] = o
+			}
+		case sameOB:
+			if inT {
+				merged[field] = t
+			}
+		default:
+			fieldPath := path + "/" + escapePointer(field)
+			om, oOk := o.(map[string]interface{})
+			tm, tOk := t.(map[string]interface{})
+			bm, bOk := b.(map[string]interface{})
+			if !inB {
+				bm, bOk = map[string]interface{}{}, true
+			}
+			if oOk && tOk && bOk {
+				var sub []string
+				merged[field], sub = mergeObjects(fieldPath, bm, om, tm)
+				conflicts = append(conflicts, sub...)
+				continue
+			}
+			// Keep our side
+			if inO {
+				merged[field] = o
+			}
+			conflicts = append(conflicts, fieldPath)
+		}
+	}
+
+	return merged, conflicts
+}
@@ -0,0 +1,170 @@
+package kvclient
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestDiff(t *testing.T) {
+	from := Snapshot{
+		"same":    "value",
+		"removed": "gone",
+		"text":    "old",
+		"json":    `{"name":"bot","enabled":true,"tags":["a","b"],"nested":{"x":1}}`,
+	}
+	to := Snapshot{
+		"same":  "value",
+		"added": "new",
+		"text":  "new",
+		"json":  `{"name":"bot","enabled":false,"tags":["a"],"nested":{"x":1,"a/b":2}}`,
+	}
+
+	changes := Diff(from, to)
+	kinds := make(map[string]ChangeKind)
+	for _, change := range changes {
+		kinds[change.Key] = change.Kind
+	}
+	expected := map[string]ChangeKind{
+		"added":   ChangeAdded,
+		"removed": ChangeRemoved,
+		"text":    ChangeModified,
+		"json":    ChangeModified,
+	}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+	if changes[0].Key != "added" {
+		t.Fatal("changes are not sorted by key", changes)
+	}
+
+	for _, change := range changes {
+		switch change.Key {
+		case "text":
+			if change.Fields != nil {
+				t.Fatal("non-JSON values should not have field changes", change.Fields)
+			}
+		case "json":
+			expected := []FieldChange{
+				{Path: "/enabled", Kind: ChangeModified, Old: true, New: false},
+				{Path: "/nested/a~1b", Kind: ChangeAdded, New: float64(2)},
+				{Path: "/tags/1", Kind: ChangeRemoved, Old: "b"},
+			}
+			if !reflect.DeepEqual(change.Fields, expected) {
+				t.Fatalf("unexpected field changes: %+v", change.Fields)
+			}
+		}
+	}
+
+	if applied := from.Apply(changes); !reflect.DeepEqual(applied, to) {
+		t.Fatalf("applying changes did not produce the target snapshot: %+v", applied)
+	}
+}
+
+func TestApplyDiff(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	if err := client.SetKeys(map[string]string{
+		"env/a": "1",
+		"env/b": "2",
+		"env/c": "3",
+	}); err != nil {
+		t.Fatal("error setting keys", err.Error())
+	}
+
+	// Compare live data with an exported file
+	var buf bytes.Buffer
+	if err := client.Export("env/", &buf); err != nil {
+		t.Fatal("error exporting keys", err.Error())
+	}
+	exported, header, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatal("error reading snapshot", err.Error())
+	}
+	if header == nil || header.Prefix != "env/" {
+		t.Fatal("unexpected snapshot header", header)
+	}
+
+	if err := client.SetKeys(map[string]string{"env/b": "changed", "env/c": "", "env/d": "4"}); err != nil {
+		t.Fatal("error setting keys", err.Error())
+	}
+	live, err := client.Snapshot("env/")
+	if err != nil {
+		t.Fatal("error reading live snapshot", err.Error())
+	}
+
+	// Roll back to the exported state, writing only the delta
+	changes := Diff(live, exported)
+	if len(changes) != 3 {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+	if err := client.ApplyDiff(changes); err != nil {
+		t.Fatal("error applying diff", err.Error())
+	}
+
+	live, err = client.Snapshot("env/")
+	if err != nil {
+		t.Fatal("error reading live snapshot", err.Error())
+	}
+	if !reflect.DeepEqual(live, exported) {
+		t.Fatalf("live data differs from export after applying diff: %+v", live)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := Snapshot{
+		"untouched": "1",
+		"ours":      "1",
+		"theirs":    "1",
+		"both":      "1",
+		"removed":   "1",
+		"config":    `{"a":1,"b":1,"c":{"x":1,"y":1}}`,
+	}
+	ours := Snapshot{
+		"untouched": "1",
+		"ours":      "2",
+		"theirs":    "1",
+		"both":      "2",
+		"config":    `{"a":2,"b":1,"c":{"x":2,"y":1}}`,
+		"new":       "ours",
+	}
+	theirs := Snapshot{
+		"untouched": "1",
+		"ours":      "1",
+		"theirs":    "3",
+		"both":      "3",
+		"removed":   "1",
+		"config":    `{"a":1,"b":3,"c":{"x":3,"y":3}}`,
+	}
+
+	merged, conflicts := Merge(base, ours, theirs)
+	expected := Snapshot{
+		"untouched": "1",
+		"ours":      "2",
+		"theirs":    "3",
+		"both":      "2",
+		"config":    `{"a":2,"b":3,"c":{"x":2,"y":3}}`,
+		"new":       "ours",
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("unexpected merge result: %+v", merged)
+	}
+
+	paths := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		paths[i] = conflict.Key + conflict.Path
+	}
+	if !reflect.DeepEqual(paths, []string{"both", "config/c/x"}) {
+		t.Fatalf("unexpected conflicts: %+v", conflicts)
+	}
+}
@@ -0,0 +1,250 @@
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// DefaultElectionPrefix is the prefix under which elections keep their keys
+const DefaultElectionPrefix = "_election/"
+
+// ElectionOptions configures an election
+type ElectionOptions struct {
+	// Prefix for the election keys, defaults to DefaultElectionPrefix.
+	// An election named "name" uses Prefix+"name/leader" for the leader lease and
+	// Prefix+"name/candidates/<id>" for candidate heartbeats.
+	Prefix string
+
+	// Lease configures the leader lease and the candidate heartbeats,
+	// HolderID is used as the candidate ID. Leases are best-effort, so
+	// elections inherit all their safety limits (see Lease).
+	Lease LeaseOptions
+}
+
+// Election is a candidacy in a leader election
+type Election struct {
+	client       *Client
+	prefix       string
+	leaderKey    string
+	candidateKey string
+	options      LeaseOptions
+
+	leader  atomic.Bool
+	changes chan bool
+
+	chn        chan KeyValuePair
+	resign     chan struct{}
+	resignOnce sync.Once
+	done       chan struct{}
+}
+
+// Elect registers as a candidate in the election with the given name, using default options.
+// The candidacy lasts until Resign is called or ctx is done.
+func (s *Client) Elect(ctx context.Context, name string) (*Election, error) {
+	return s.ElectWithOptions(ctx, name, ElectionOptions{})
+}
+
+// ElectWithOptions registers as a candidate in the election with the given name.
+// The candidacy lasts until Resign is called or ctx is done.
+func (s *Client) ElectWithOptions(ctx context.Context, name string, options ElectionOptions) (*Election, error) {
+	if options.Prefix == "" {
+		options.Prefix = DefaultElectionPrefix
+	}
+	lease := options.Lease.withDefaults()
+	prefix := options.Prefix + name + "/"
+
+	election := &Election{
+		client:       s,
+		prefix:       prefix,
+		leaderKey:    prefix + "leader",
+		candidateKey: prefix + "candidates/" + lease.HolderID,
+		options:      lease,
+		changes:      make(chan bool, 1),
+		chn:          make(chan KeyValuePair, 1),
+		resign:       make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	// run sends requests on this client while it's waiting for pushes, so the read
+	// loop must never block on this channel: only the latest leader lease is kept.
+	if err := s.subscribeKey(election.leaderKey, &subscriber{ch: election.chn, filter: func(pair KeyValuePair) (KeyValuePair, bool) {
+		select {
+		case <-election.chn:
+		default:
+		}
+		return pair, true
+	}}); err != nil {
+		return nil, err
+	}
+	if err := election.heartbeat(); err != nil {
+		_ = s.UnsubscribeKey(election.leaderKey, election.chn)
+		return nil, err
+	}
+
+	go election.run(ctx)
+	return election, nil
+}
+
+// ID returns the candidate ID
+func (e *Election) ID() string {
+	return e.options.HolderID
+}
+
+// IsLeader returns whether this candidate is currently the leader
+func (e *Election) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Changes returns a channel receiving true when this candidate becomes the leader
+// and false when it stops being the leader. If the receiver falls behind, only the
+// most recent state is kept. The channel is closed when the candidacy ends.
+func (e *Election) Changes() <-chan bool {
+	return e.changes
+}
+
+// Leader returns the ID of the current leader, or an empty string if there is none
+func (e *Election) Leader() (string, error) {
+	value, err := e.client.GetKey(e.leaderKey)
+	if err != nil {
+		return "", err
+	}
+	return parseLease(value).heldBy(time.Now()), nil
+}
+
+// Candidates returns the IDs of all candidates that are still sending heartbeats
+func (e *Election) Candidates() ([]string, error) {
+	values, err := e.client.GetByPrefix(e.prefix + "candidates/")
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	now := time.Now()
+	for _, value := range values {
+		if holder := parseLease(value).heldBy(now); holder != "" {
+			candidates = append(candidates, holder)
+		}
+	}
+	return candidates, nil
+}
+
+// Resign ends the candidacy, giving up leadership if this candidate is the leader
+func (e *Election) Resign() error {
+	e.resignOnce.Do(func() {
+		close(e.resign)
+	})
+	<-e.done
+	return nil
+}
+
+func (e *Election) setLeader(leader bool) {
+	if e.leader.Swap(leader) == leader {
+		return
+	}
+	// Only keep the latest state
+	select {
+	case <-e.changes:
+	default:
+	}
+	e.changes <- leader
+}
+
+func (e *Election) heartbeat() error {
+	value, err := jsoniter.ConfigFastest.MarshalToString(LeaseValue{
+		Holder:  e.options.HolderID,
+		Expires: time.Now().Add(e.options.TTL),
+	})
+	if err != nil {
+		return err
+	}
+	return e.client.SetKey(e.candidateKey, value)
+}
+
+func (e *Election) run(ctx context.Context) {
+	var lease *Lease
+	defer func() {
+		if lease != nil {
+			_ = lease.Release()
+		}
+		e.setLeader(false)
+		_ = e.client.SetKey(e.candidateKey, "")
+		_ = e.client.UnsubscribeKey(e.leaderKey, e.chn)
+		close(e.changes)
+		close(e.done)
+	}()
+
+	ticker := time.NewTicker(e.options.RenewInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		lease, err = e.client.TryAcquireLease(e.leaderKey, e.options)
+		var wait time.Duration
+		switch {
+		case err == nil:
+			e.setLeader(true)
+		case errors.Is(err, ErrLeaseHeld):
+			wait = e.options.TTL
+		default:
+			e.client.Logger.Warn("could not run for election", "key", e.leaderKey, "error", err)
+			wait = e.options.RenewInterval
+		}
+
+		var lost <-chan struct{}
+		if lease != nil {
+			lost = lease.Lost()
+		} else if value, err := e.client.GetKey(e.leaderKey); err == nil {
+			if current := parseLease(value); current.heldBy(time.Now()) != "" {
+				wait = time.Until(current.Expires)
+			}
+		}
+
+		// As leader, wait for the lease to be lost. As follower, wait for the leader
+		// to go away, either by freeing the lease or by letting it expire.
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if lease == nil {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-e.resign:
+				return
+			case <-ticker.C:
+				if err := e.heartbeat(); err != nil {
+					e.client.Logger.Warn("could not send candidate heartbeat", "key", e.candidateKey, "error", err)
+				}
+			case <-lost:
+				_ = lease.Release()
+				lease = nil
+				e.setLeader(false)
+				break wait
+			case <-timeout:
+				break wait
+			case push := <-e.chn:
+				if lease != nil {
+					continue
+				}
+				current := parseLease(push.Value)
+				if current.heldBy(time.Now()) == "" {
+					break wait
+				}
+				// The leader renewed its lease, wait for the new expiry time
+				timer.Reset(time.Until(current.Expires))
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package kvclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// leaseWrite is a write to a lease key as seen by another client
+type leaseWrite struct {
+	LeaseValue
+	received time.Time
+}
+
+// watchLease records every write to a lease key
+func watchLease(t *testing.T, client *Client, key string) func() []leaseWrite {
+	chn, err := client.SubscribeKey(key)
+	if err != nil {
+		t.Fatal("error subscribing to lease", err.Error())
+	}
+
+	var mu sync.Mutex
+	var writes []leaseWrite
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case push := <-chn:
+				mu.Lock()
+				writes = append(writes, leaseWrite{parseLease(push.Value), time.Now()})
+				mu.Unlock()
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		_ = client.UnsubscribeKey(key, chn)
+		close(done)
+	})
+
+	return func() []leaseWrite {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]leaseWrite(nil), writes...)
+	}
+}
+
+func TestElection(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	options := ElectionOptions{
+		Lease: LeaseOptions{
+			TTL:         300 * time.Millisecond,
+			SettleDelay: 10 * time.Millisecond,
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	observer, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	writes := watchLease(t, observer, DefaultElectionPrefix+"bot/leader")
+
+	clients := make([]*Client, 3)
+	elections := make([]*Election, 3)
+	for i := range clients {
+		var err error
+		clients[i], err = NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+		if err != nil {
+			t.Fatal("error creating kv client", err.Error())
+		}
+		elections[i], err = clients[i].ElectWithOptions(ctx, "bot", options)
+		if err != nil {
+			t.Fatal("error joining election", err.Error())
+		}
+	}
+
+	// currentLeader returns the only candidate that thinks it's the leader, or -1
+	currentLeader := func() int {
+		found := -1
+		for i, election := range elections {
+			if election == nil || !election.IsLeader() {
+				continue
+			}
+			if found >= 0 {
+				return -1
+			}
+			found = i
+		}
+		return found
+	}
+	leader := func() int {
+		waitFor(t, "a single leader", func() bool {
+			return currentLeader() >= 0
+		})
+		return currentLeader()
+	}
+
+	// Leases are best-effort: candidates racing for a free lease may briefly both
+	// think they won, and a leader too slow to renew in time loses its lease. What
+	// must never happen is someone taking over a lease that was renewed on time and
+	// hasn't expired yet. Wait for a leader that kept renewing for a while.
+	waitFor(t, "the leader to renew its lease", func() bool {
+		var current LeaseValue
+		var renewals int
+		onTime := false
+		for _, write := range writes() {
+			if write.Holder != current.Holder {
+				if onTime && current.heldBy(write.received) != "" {
+					t.Fatal("lease taken over while the leader was alive", current.Holder, write.Holder)
+				}
+				renewals, onTime = 0, false
+			} else {
+				renewals++
+				onTime = current.heldBy(write.received) != ""
+			}
+			current = write.LeaseValue
+		}
+		return renewals >= 3 && onTime
+	})
+	notified := make([]bool, len(elections))
+	waitFor(t, "the leader to be notified", func() bool {
+		for i, election := range elections {
+			select {
+			case notified[i] = <-election.Changes():
+			default:
+			}
+		}
+		current := currentLeader()
+		return current >= 0 && notified[current]
+	})
+	// Reads only see live leases, so wait for the state rather than reading it once
+	waitFor(t, "followers to see the leader", func() bool {
+		current := currentLeader()
+		if current < 0 {
+			return false
+		}
+		id, err := elections[(current+1)%3].Leader()
+		if err != nil {
+			t.Fatal("error getting leader", err.Error())
+		}
+		return id == elections[current].ID()
+	})
+	waitFor(t, "all candidates", func() bool {
+		candidates, err := elections[0].Candidates()
+		if err != nil {
+			t.Fatal("error listing candidates", err.Error())
+		}
+		return len(candidates) == 3
+	})
+	first := leader()
+
+	// Simulate a crash: the leader stops heartbeating without resigning
+	_ = clients[first].Close()
+	crashed := elections[first]
+	elections[first] = nil
+	second := leader()
+	if second == first {
+		t.Fatal("crashed leader is still leading")
+	}
+	waitFor(t, "crashed leader to step down", func() bool {
+		return !crashed.IsLeader()
+	})
+
+	// Resigning hands leadership over without waiting for the lease to expire
+	if err := elections[second].Resign(); err != nil {
+		t.Fatal("error resigning", err.Error())
+	}
+	if elections[second].IsLeader() {
+		t.Fatal("resigned candidate is still leader")
+	}
+	// The channel is closed once the candidacy is over
+	for range elections[second].Changes() {
+	}
+	resigned := elections[second].ID()
+	elections[second] = nil
+	third := leader()
+
+	var before, after LeaseValue
+	waitFor(t, "the new lease to be seen", func() bool {
+		for _, write := range writes() {
+			switch {
+			case write.Holder == resigned:
+				before, after = write.LeaseValue, LeaseValue{}
+			case write.Holder == elections[third].ID() && after.Holder == "":
+				after = write.LeaseValue
+			}
+		}
+		return before.Holder != "" && after.Holder != ""
+	})
+	if acquired := after.Expires.Add(-options.Lease.TTL); !acquired.Before(before.Expires) {
+		t.Fatal("takeover after resign waited for the lease to expire", acquired, before.Expires)
+	}
+
+	// Ending the context ends the candidacy too
+	cancel()
+	waitFor(t, "last leader to step down", func() bool {
+		return !elections[third].IsLeader()
+	})
+}
+
+func TestElectionBusyPrefix(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	other, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	election, err := client.ElectWithOptions(context.Background(), "busy", ElectionOptions{
+		Lease: LeaseOptions{TTL: 30 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal("error joining election", err.Error())
+	}
+
+	// Lots of writes under the election prefix while the candidate keeps sending heartbeats
+	for i := 0; i < 500; i++ {
+		if err := other.SetKey(DefaultElectionPrefix+"busy/candidates/someone", fmt.Sprint(i)); err != nil {
+			t.Fatal("error setting key", err.Error())
+		}
+	}
+
+	resigned := make(chan error, 1)
+	go func() { resigned <- election.Resign() }()
+	select {
+	case err := <-resigned:
+		if err != nil {
+			t.Fatal("error resigning", err.Error())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("election stopped responding while its prefix was busy")
+	}
+}
@@ -0,0 +1,155 @@
+package kvclient
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrNotEncrypted         = errors.New("value is not encrypted")
+	ErrUnknownEncryptionKey = errors.New("value is encrypted with an unknown key")
+	ErrTampered             = errors.New("encrypted value was tampered with or moved to a different key")
+)
+
+// encryptedPrefix starts every encrypted value, followed by "<key ID>:<base64 nonce+ciphertext>"
+const encryptedPrefix = "enc:v1:"
+
+// EncryptionKey is an AES key (16, 24 or 32 bytes) with an ID that's stored
+// alongside every value it encrypts, so the right key can be picked to decrypt it
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// EncryptionOptions configures an encryption codec
+type EncryptionOptions struct {
+	// Keys used to decrypt values. The first one is also used to encrypt new values,
+	// so rotating keys means putting the new key first and keeping the old ones
+	// until ReencryptPrefix has been run.
+	Keys []EncryptionKey
+
+	// AllowPlaintext lets values that are not encrypted be read as they are,
+	// e.g. while migrating existing keys. Otherwise, reading them fails with ErrNotEncrypted.
+	AllowPlaintext bool
+}
+
+// EncryptionCodec is a ValueCodec that encrypts values with AES-GCM. The key
+// name is authenticated together with the value, so encrypted values can't be
+// copied to a different key without failing with ErrTampered.
+type EncryptionCodec struct {
+	current        string
+	aeads          map[string]cipher.AEAD
+	allowPlaintext bool
+}
+
+// NewEncryptionCodec creates an encryption codec, use it as ClientOptions.ValueCodec
+// (or in PrefixCodec to only encrypt some keys)
+func NewEncryptionCodec(options EncryptionOptions) (*EncryptionCodec, error) {
+	if len(options.Keys) < 1 {
+		return nil, errors.New("at least one encryption key is required")
+	}
+
+	codec := &EncryptionCodec{
+		current:        options.Keys[0].ID,
+		aeads:          make(map[string]cipher.AEAD, len(options.Keys)),
+		allowPlaintext: options.AllowPlaintext,
+	}
+	for _, key := range options.Keys {
+		if key.ID == "" || strings.Contains(key.ID, ":") {
+			return nil, fmt.Errorf("invalid encryption key ID %q", key.ID)
+		}
+		if _, ok := codec.aeads[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate encryption key ID %q", key.ID)
+		}
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %q: %w", key.ID, err)
+		}
+		codec.aeads[key.ID], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codec, nil
+}
+
+// additionalData binds a ciphertext to the key it's stored in and the key ID it claims
+func additionalData(key string, keyID string) []byte {
+	return []byte(key + "\x00" + keyID)
+}
+
+// Encode encrypts value with the current key
+func (c *EncryptionCodec) Encode(key string, value string) (string, error) {
+	aead := c.aeads[c.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), additionalData(key, c.current))
+	return encryptedPrefix + c.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decode decrypts value with the key it was encrypted with
+func (c *EncryptionCodec) Decode(key string, value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		if c.allowPlaintext {
+			return value, nil
+		}
+		return "", ErrNotEncrypted
+	}
+
+	keyID, encoded, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !ok {
+		return "", ErrTampered
+	}
+	aead, ok := c.aeads[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownEncryptionKey, keyID)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrTampered
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData(key, keyID))
+	if err != nil {
+		return "", ErrTampered
+	}
+	return string(plain), nil
+}
+
+// NeedsRotation returns true if a stored (encrypted) value is not encrypted with the current key
+func (c *EncryptionCodec) NeedsRotation(value string) bool {
+	return value != "" && !strings.HasPrefix(value, encryptedPrefix+c.current+":")
+}
+
+// ReencryptPrefix reads and writes back every key starting with prefix, so that
+// they are encoded again by ClientOptions.ValueCodec (e.g. with the newest
+// encryption key after a rotation). It returns the number of keys rewritten.
+func (s *Client) ReencryptPrefix(prefix string) (int, error) {
+	if s.codec == nil {
+		return 0, errors.New("no value codec set")
+	}
+
+	values, err := s.GetByPrefix(prefix)
+	if err != nil {
+		return 0, err
+	}
+	data := make(map[string]string, len(values))
+	for key, value := range values {
+		if value != "" {
+			data[key] = value
+		}
+	}
+	if len(data) < 1 {
+		return 0, nil
+	}
+	if err := s.SetKeys(data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
@@ -0,0 +1,176 @@
+package kvclient
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func testEncryptionCodec(t *testing.T, keys ...EncryptionKey) *EncryptionCodec {
+	codec, err := NewEncryptionCodec(EncryptionOptions{Keys: keys})
+	if err != nil {
+		t.Fatal("error creating codec", err.Error())
+	}
+	return codec
+}
+
+func TestEncryption(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	key1 := EncryptionKey{ID: "k1", Key: bytes.Repeat([]byte{1}, 32)}
+	errs := make(chan error, 10)
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+		ValueCodec: PrefixCodec(map[string]ValueCodec{
+			"secrets/": testEncryptionCodec(t, key1),
+		}),
+		OnError: func(err error) { errs <- err },
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	raw, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	chn, err := client.SubscribePrefix("secrets/")
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+
+	if err := client.SetKey("secrets/token", "hunter2"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	if err := client.SetJSON("secrets/oauth", map[string]string{"token": "abc"}); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	if err := client.SetKey("public", "hello"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+
+	// Only keys under the prefix are stored encrypted
+	stored, _ := raw.GetKeys([]string{"secrets/token", "secrets/oauth", "public"})
+	if !strings.HasPrefix(stored["secrets/token"], "enc:v1:k1:") || strings.Contains(stored["secrets/token"], "hunter2") {
+		t.Fatal("value not stored encrypted", stored["secrets/token"])
+	}
+	if stored["public"] != "hello" {
+		t.Fatal("value outside of prefix was encoded", stored["public"])
+	}
+
+	if value, _ := client.GetKey("secrets/token"); value != "hunter2" {
+		t.Fatal("wrong decrypted value", value)
+	}
+	var oauth map[string]string
+	if err := client.GetJSON("secrets/oauth", &oauth); err != nil || oauth["token"] != "abc" {
+		t.Fatal("wrong decrypted JSON", oauth, err)
+	}
+	if values, _ := client.GetByPrefix("secrets/"); values["secrets/token"] != "hunter2" {
+		t.Fatal("wrong decrypted values", values)
+	}
+	for _, expected := range []string{"hunter2", `{"token":"abc"}`} {
+		select {
+		case push := <-chn:
+			if push.Value != expected {
+				t.Fatal("wrong decrypted push", push)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for push")
+		}
+	}
+
+	// Moving a ciphertext to another key must not decrypt
+	if err := raw.SetKey("secrets/copy", stored["secrets/token"]); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	if _, err := client.GetKey("secrets/copy"); !errors.Is(err, ErrTampered) {
+		t.Fatal("expected ErrTampered for moved value, got", err)
+	}
+
+	// Tampered pushes are dropped and reported
+	tampered := stored["secrets/token"][:len(stored["secrets/token"])-2] + "AA"
+	if err := raw.SetKey("secrets/token", tampered); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	if _, err := client.GetKey("secrets/token"); !errors.Is(err, ErrTampered) {
+		t.Fatal("expected ErrTampered, got", err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrTampered) {
+			t.Fatal("unexpected error reported", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tampered push was not reported")
+	}
+	for len(chn) > 0 {
+		if push := <-chn; push.Key == "secrets/token" {
+			t.Fatal("tampered push was delivered", push)
+		}
+	}
+
+	if err := raw.SetKey("secrets/plain", "oops"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	if _, err := client.GetKey("secrets/plain"); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatal("expected ErrNotEncrypted, got", err)
+	}
+}
+
+func TestEncryptionRotation(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	key1 := EncryptionKey{ID: "k1", Key: bytes.Repeat([]byte{1}, 32)}
+	key2 := EncryptionKey{ID: "k2", Key: bytes.Repeat([]byte{2}, 32)}
+
+	old, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log), ValueCodec: testEncryptionCodec(t, key1)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	if err := old.SetKeys(map[string]string{"tokens/a": "1", "tokens/b": "2"}); err != nil {
+		t.Fatal("error setting keys", err.Error())
+	}
+
+	rotated := testEncryptionCodec(t, key2, key1)
+	client, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log), ValueCodec: rotated})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	if value, _ := client.GetKey("tokens/a"); value != "1" {
+		t.Fatal("could not read value encrypted with old key", value)
+	}
+
+	count, err := client.ReencryptPrefix("tokens/")
+	if err != nil || count != 2 {
+		t.Fatal("error re-encrypting", count, err)
+	}
+
+	raw, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	stored, _ := raw.GetByPrefix("tokens/")
+	for key, value := range stored {
+		if rotated.NeedsRotation(value) {
+			t.Fatal("value not re-encrypted", key, value)
+		}
+	}
+
+	// The old key can now be dropped
+	latest, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log), ValueCodec: testEncryptionCodec(t, key2)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	if values, err := latest.GetByPrefix("tokens/"); err != nil || values["tokens/a"] != "1" || values["tokens/b"] != "2" {
+		t.Fatal("wrong values after rotation", values, err)
+	}
+	if _, err := old.GetKey("tokens/a"); !errors.Is(err, ErrUnknownEncryptionKey) {
+		t.Fatal("expected ErrUnknownEncryptionKey, got", err)
+	}
+}
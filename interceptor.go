@@ -0,0 +1,118 @@
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kv "github.com/strimertul/kilovolt/v11"
+)
+
+// Invoker sends a request and returns its response
+type Invoker func(ctx context.Context, request kv.Request) (kv.Response, error)
+
+// Interceptor wraps a request. It can inspect or modify the request, call next
+// (any number of times) to continue down the chain, and inspect or modify the
+// response. Not calling next short-circuits the request.
+type Interceptor func(ctx context.Context, request kv.Request, next Invoker) (kv.Response, error)
+
+// PushHandler handles a push received from the server
+type PushHandler func(push KeyValuePair)
+
+// PushInterceptor wraps the delivery of a push to subscribers. It can modify the
+// push before calling next, or drop it by not calling next at all.
+type PushInterceptor func(push KeyValuePair, next PushHandler)
+
+// ServerError is returned when the server replies to a request with an error
+type ServerError struct {
+	Message string
+	Details string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Message, e.Details)
+}
+
+func chainInterceptors(interceptors []Interceptor, final Invoker) Invoker {
+	invoker := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, request kv.Request) (kv.Response, error) {
+			return interceptor(ctx, request, next)
+		}
+	}
+	return invoker
+}
+
+func chainPushInterceptors(interceptors []PushInterceptor, final PushHandler) PushHandler {
+	handler := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(push KeyValuePair) {
+			interceptor(push, next)
+		}
+	}
+	return handler
+}
+
+// authInterceptor makes sure the connection is authenticated before any request
+// goes through, authenticating again whenever a new connection is opened
+func (s *Client) authInterceptor(password string) Interceptor {
+	var mu sync.Mutex
+	var authenticated uint64 // Generation of the last authenticated connection
+
+	return func(ctx context.Context, request kv.Request, next Invoker) (kv.Response, error) {
+		switch request.CmdName {
+		case kv.CmdAuthRequest:
+			return next(ctx, request)
+		case kv.CmdAuthChallenge:
+			generation := atomic.LoadUint64(&s.generation)
+			res, err := next(ctx, request)
+			if err == nil {
+				mu.Lock()
+				authenticated = generation
+				mu.Unlock()
+			}
+			return res, err
+		}
+
+		mu.Lock()
+		if generation := atomic.LoadUint64(&s.generation); authenticated != generation {
+			if err := s.authenticate(ctx, password, next); err != nil {
+				mu.Unlock()
+				return kv.Response{}, fmt.Errorf("authentication failed: %w", err)
+			}
+			authenticated = generation
+		}
+		mu.Unlock()
+
+		return next(ctx, request)
+	}
+}
+
+// RetryInterceptor retries requests that failed for reasons other than the server
+// replying with an error (eg. a recycled connection), up to attempts times in total.
+// The wait between attempts starts at backoff and doubles every time.
+func RetryInterceptor(attempts int, backoff time.Duration) Interceptor {
+	return func(ctx context.Context, request kv.Request, next Invoker) (kv.Response, error) {
+		wait := backoff
+		for attempt := 1; ; attempt++ {
+			res, err := next(ctx, request)
+
+			var serverError *ServerError
+			if err == nil || attempt >= attempts || errors.As(err, &serverError) || ctx.Err() != nil {
+				return res, err
+			}
+
+			select {
+			case <-time.After(wait):
+				wait *= 2
+			case <-ctx.Done():
+				return kv.Response{}, ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	kv "github.com/strimertul/kilovolt/v11"
+)
+
+func TestInterceptors(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	var calls []string
+	logging := func(name string) Interceptor {
+		return func(ctx context.Context, request kv.Request, next Invoker) (kv.Response, error) {
+			calls = append(calls, name+">"+request.CmdName)
+			res, err := next(ctx, request)
+			calls = append(calls, name+"<"+request.CmdName)
+			return res, err
+		}
+	}
+	rewrite := func(ctx context.Context, request kv.Request, next Invoker) (kv.Response, error) {
+		if key, ok := request.Data["key"].(string); ok {
+			request.Data["key"] = "rewritten/" + key
+		}
+		return next(ctx, request)
+	}
+	errDenied := errors.New("denied")
+	readOnly := func(ctx context.Context, request kv.Request, next Invoker) (kv.Response, error) {
+		if request.CmdName == kv.CmdWriteKey && strings.HasPrefix(request.Data["key"].(string), "rewritten/locked") {
+			return kv.Response{}, errDenied
+		}
+		return next(ctx, request)
+	}
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger:       ZapLogger(log),
+		Interceptors: []Interceptor{logging("first"), logging("second"), rewrite, readOnly},
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	if err = client.SetKey("test", "test1234"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	expected := []string{"first>kset", "second>kset", "second<kset", "first<kset"}
+	if strings.Join(calls, ",") != strings.Join(expected, ",") {
+		t.Fatalf("interceptors ran in the wrong order, expected=%v got=%v", expected, calls)
+	}
+
+	if err = client.SetKey("locked", "value"); !errors.Is(err, errDenied) {
+		t.Fatal("expected write to be denied by interceptor, got", err)
+	}
+
+	// Check the key was rewritten using a client without interceptors
+	plain, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	val, err := plain.GetKey("rewritten/test")
+	if err != nil {
+		t.Fatal("error getting key", err.Error())
+	}
+	if val != "test1234" {
+		t.Fatalf("returned value is different than expected, expected=%s got=%s", "test1234", val)
+	}
+}
+
+func TestPushInterceptors(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+		PushInterceptors: []PushInterceptor{
+			func(push KeyValuePair, next PushHandler) {
+				// Drop pushes for hidden keys
+				if !strings.HasSuffix(push.Key, "hidden") {
+					next(push)
+				}
+			},
+			func(push KeyValuePair, next PushHandler) {
+				push.Value = strings.ToUpper(push.Value)
+				next(push)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	chn, err := client.SubscribePrefix("push/")
+	if err != nil {
+		t.Fatal("error subscribing to prefix", err.Error())
+	}
+
+	if err = client.SetKey("push/hidden", "secret"); err != nil {
+		t.Fatal("error modifying key", err.Error())
+	}
+	if err = client.SetKey("push/visible", "hello"); err != nil {
+		t.Fatal("error modifying key", err.Error())
+	}
+
+	select {
+	case <-time.After(20 * time.Second):
+		t.Fatal("push took too long to arrive")
+	case push := <-chn:
+		if push.Key != "push/visible" || push.Value != "HELLO" {
+			t.Fatal("wrong value received", push)
+		}
+	}
+}
+
+func TestRetryInterceptor(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+
+	server := createScriptedServer(t, func(conn int, req kv.Request) string {
+		// First connection only ever replies with garbage
+		if conn == 1 {
+			return "garbage"
+		}
+		return fmt.Sprintf("{\"type\":\"response\",\"ok\":true,\"request_id\":\"%s\",\"data\":\"value\"}", req.RequestID)
+	})
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger:             ZapLogger(log),
+		MaxMalformedFrames: 1,
+		Interceptors:       []Interceptor{RetryInterceptor(3, 10*time.Millisecond)},
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	val, err := client.GetKey("test")
+	if err != nil {
+		t.Fatal("error getting key", err.Error())
+	}
+	if val != "value" {
+		t.Fatalf("returned value is different than expected, expected=%s got=%s", "value", val)
+	}
+}
+
+func TestRetryInterceptorServerError(t *testing.T) {
+	attempts := 0
+	retry := RetryInterceptor(3, time.Millisecond)
+	_, err := retry(context.Background(), kv.Request{}, func(ctx context.Context, request kv.Request) (kv.Response, error) {
+		attempts++
+		return kv.Response{}, &ServerError{Message: "authentication required"}
+	})
+
+	var serverError *ServerError
+	if !errors.As(err, &serverError) {
+		t.Fatal("expected server error, got", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("server errors should not be retried, got %d attempts", attempts)
+	}
+}
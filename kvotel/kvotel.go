@@ -0,0 +1,154 @@
+// Package kvotel provides OpenTelemetry tracing for kilovolt clients, in the
+// form of interceptors to add to kvclient.ClientOptions.
+package kvotel
+
+import (
+	"context"
+	"errors"
+
+	jsoniter "github.com/json-iterator/go"
+	kv "github.com/strimertul/kilovolt/v11"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	kvclient "github.com/strimertul/kilovolt-client-go/v11"
+)
+
+const instrumentationName = "github.com/strimertul/kilovolt-client-go/v11/kvotel"
+
+// Attribute keys set on spans
+const (
+	AttrCommand     = attribute.Key("kilovolt.command")
+	AttrKey         = attribute.Key("kilovolt.key")
+	AttrPrefix      = attribute.Key("kilovolt.prefix")
+	AttrKeyCount    = attribute.Key("kilovolt.key_count")
+	AttrRequestID   = attribute.Key("kilovolt.request_id")
+	AttrPayloadSize = attribute.Key("kilovolt.payload_size")
+	AttrErrorClass  = attribute.Key("kilovolt.error_class")
+)
+
+// Error classes reported in AttrErrorClass
+const (
+	ErrorClassServer     = "server"
+	ErrorClassConnection = "connection"
+	ErrorClassTimeout    = "timeout"
+	ErrorClassCanceled   = "canceled"
+	ErrorClassOther      = "other"
+)
+
+type config struct {
+	provider trace.TracerProvider
+}
+
+// Option configures the interceptors
+type Option func(*config)
+
+// WithTracerProvider sets the tracer provider to use, defaults to the global one
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+func newTracer(opts []Option) trace.Tracer {
+	cfg := config{
+		provider: otel.GetTracerProvider(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg.provider.Tracer(instrumentationName)
+}
+
+// Interceptor returns a request interceptor that creates a span for every request.
+// Put it first in kvclient.ClientOptions.Interceptors to have the span cover the whole chain.
+func Interceptor(opts ...Option) kvclient.Interceptor {
+	tracer := newTracer(opts)
+
+	return func(ctx context.Context, request kv.Request, next kvclient.Invoker) (kv.Response, error) {
+		ctx, span := tracer.Start(ctx, "kilovolt "+request.CmdName,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(requestAttributes(request)...))
+		defer span.End()
+
+		res, err := next(ctx, request)
+		if err != nil {
+			span.SetAttributes(AttrErrorClass.String(ErrorClass(err)))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		return res, err
+	}
+}
+
+// PushInterceptor returns a push interceptor that creates a span for every push
+// received, with an event once the push has been delivered to subscriptions
+func PushInterceptor(opts ...Option) kvclient.PushInterceptor {
+	tracer := newTracer(opts)
+
+	return func(push kvclient.KeyValuePair, next kvclient.PushHandler) {
+		_, span := tracer.Start(context.Background(), "kilovolt push",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(AttrKey.String(push.Key), AttrPayloadSize.Int(len(push.Value))))
+		defer span.End()
+
+		next(push)
+		span.AddEvent("delivered")
+	}
+}
+
+func requestAttributes(request kv.Request) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		AttrCommand.String(request.CmdName),
+		AttrRequestID.String(request.RequestID),
+	}
+
+	if key, ok := request.Data["key"].(string); ok {
+		attrs = append(attrs, AttrKey.String(key))
+	}
+	if prefix, ok := request.Data["prefix"].(string); ok {
+		attrs = append(attrs, AttrPrefix.String(prefix))
+	}
+	switch request.CmdName {
+	case kv.CmdReadBulk:
+		if keys, ok := request.Data["keys"].([]string); ok {
+			attrs = append(attrs, AttrKeyCount.Int(len(keys)))
+		}
+	case kv.CmdWriteBulk:
+		attrs = append(attrs, AttrKeyCount.Int(len(request.Data)))
+	}
+
+	if payload, err := jsoniter.ConfigFastest.Marshal(request.Data); err == nil {
+		attrs = append(attrs, AttrPayloadSize.Int(len(payload)))
+	}
+
+	return attrs
+}
+
+// ErrorClass returns a coarse classification of a request error
+func ErrorClass(err error) string {
+	var serverError *kvclient.ServerError
+	switch {
+	case errors.As(err, &serverError):
+		return ErrorClassServer
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, kvclient.ErrConnectionRecycled):
+		return ErrorClassConnection
+	}
+
+	var netError interface{ Timeout() bool }
+	if errors.As(err, &netError) {
+		if netError.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassConnection
+	}
+
+	return ErrorClassOther
+}
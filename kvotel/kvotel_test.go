@@ -0,0 +1,178 @@
+package kvotel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kv "github.com/strimertul/kilovolt/v11"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+
+	kvclient "github.com/strimertul/kilovolt-client-go/v11"
+)
+
+func TestRequestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	log, _ := zap.NewDevelopment()
+	server := createInMemoryKV(t, log)
+
+	client, err := kvclient.NewClient(server.URL, kvclient.ClientOptions{
+		Logger:             kvclient.ZapLogger(log),
+		Interceptors:       []kvclient.Interceptor{Interceptor(WithTracerProvider(provider))},
+		RequestIDGenerator: kvclient.PrefixedIDGenerator("trace-", nil),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	if err = client.SetKey("test", "test1234"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("wrong number of spans recorded, expected=1 got=%d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "kilovolt kset" {
+		t.Fatal("wrong span name", span.Name())
+	}
+	attrs := attributeMap(span.Attributes())
+	if attrs[AttrCommand] != kv.CmdWriteKey {
+		t.Fatal("wrong command attribute", attrs[AttrCommand])
+	}
+	if attrs[AttrKey] != "test" {
+		t.Fatal("wrong key attribute", attrs[AttrKey])
+	}
+	if attrs[AttrRequestID] != "trace-1" {
+		t.Fatal("wrong request ID attribute", attrs[AttrRequestID])
+	}
+	if size, ok := attrs[AttrPayloadSize].(int64); !ok || size <= 0 {
+		t.Fatal("missing payload size attribute", attrs[AttrPayloadSize])
+	}
+	if span.Status().Code == codes.Error {
+		t.Fatal("successful request has error status")
+	}
+}
+
+func TestErrorSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	log, _ := zap.NewDevelopment()
+	server := createInMemoryKV(t, log)
+
+	client, err := kvclient.NewClient(server.URL, kvclient.ClientOptions{
+		Logger: kvclient.ZapLogger(log),
+		Interceptors: []kvclient.Interceptor{
+			Interceptor(WithTracerProvider(provider)),
+			func(ctx context.Context, request kv.Request, next kvclient.Invoker) (kv.Response, error) {
+				return kv.Response{}, &kvclient.ServerError{Message: "denied"}
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	if _, err = client.GetKey("test"); err == nil {
+		t.Fatal("expected request to fail")
+	}
+
+	spans := recorder.Ended()
+	span := spans[len(spans)-1]
+	if span.Status().Code != codes.Error {
+		t.Fatal("failed request doesn't have error status")
+	}
+	if class := attributeMap(span.Attributes())[AttrErrorClass]; class != ErrorClassServer {
+		t.Fatal("wrong error class", class)
+	}
+}
+
+func TestPushSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	log, _ := zap.NewDevelopment()
+	server := createInMemoryKV(t, log)
+
+	client, err := kvclient.NewClient(server.URL, kvclient.ClientOptions{
+		Logger:           kvclient.ZapLogger(log),
+		PushInterceptors: []kvclient.PushInterceptor{PushInterceptor(WithTracerProvider(provider))},
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	chn, err := client.SubscribeKey("subtest")
+	if err != nil {
+		t.Fatal("error subscribing to key", err.Error())
+	}
+	if err = client.SetKey("subtest", "testvalue1234"); err != nil {
+		t.Fatal("error modifying key", err.Error())
+	}
+	select {
+	case <-time.After(20 * time.Second):
+		t.Fatal("push took too long to arrive")
+	case <-chn:
+	}
+
+	// The span ends right after delivery, give it a moment
+	var spans []sdktrace.ReadOnlySpan
+	for i := 0; i < 100 && len(spans) < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+		spans = recorder.Ended()
+	}
+	if len(spans) != 1 {
+		t.Fatalf("wrong number of spans recorded, expected=1 got=%d", len(spans))
+	}
+	if key := attributeMap(spans[0].Attributes())[AttrKey]; key != "subtest" {
+		t.Fatal("wrong key attribute", key)
+	}
+	events := spans[0].Events()
+	if len(events) != 1 || events[0].Name != "delivered" {
+		t.Fatal("missing delivery event", events)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	if class := ErrorClass(kvclient.ErrConnectionRecycled); class != ErrorClassConnection {
+		t.Fatal("wrong error class for recycled connection", class)
+	}
+	if class := ErrorClass(errors.New("something")); class != ErrorClassOther {
+		t.Fatal("wrong error class for generic error", class)
+	}
+}
+
+func attributeMap(attrs []attribute.KeyValue) map[attribute.Key]interface{} {
+	result := make(map[attribute.Key]interface{})
+	for _, attr := range attrs {
+		result[attr.Key] = attr.Value.AsInterface()
+	}
+	return result
+}
+
+func createInMemoryKV(t *testing.T, log *zap.Logger) *httptest.Server {
+	// Create hub with in-mem DB
+	hub, err := kv.NewHub(kv.MakeBackend(), kv.HubOptions{}, log)
+	if err != nil {
+		t.Fatal("hub initialization failed", err.Error())
+	}
+	go hub.Run()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kv.ServeWs(hub, w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
@@ -0,0 +1,186 @@
+// Package kvprom implements kvclient.Metrics as a Prometheus collector.
+//
+//	collector := kvprom.NewCollector(kvprom.CollectorOptions{})
+//	prometheus.MustRegister(collector)
+//	client, err := kvclient.NewClient(endpoint, kvclient.ClientOptions{Metrics: collector})
+package kvprom
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	kvclient "github.com/strimertul/kilovolt-client-go/v11"
+)
+
+// CollectorOptions configures a Collector
+type CollectorOptions struct {
+	// Namespace is prepended to all metric names, defaults to "kilovolt_client"
+	Namespace string
+
+	// ConstLabels are added to all metrics, useful to tell multiple clients apart
+	ConstLabels prometheus.Labels
+
+	// PrefixLabel maps a key to the value of the "prefix" label used in push
+	// metrics, to keep cardinality under control. Defaults to DefaultPrefixLabel.
+	PrefixLabel func(key string) string
+
+	// Buckets for the request duration histogram, defaults to prometheus.DefBuckets
+	Buckets []float64
+}
+
+// DefaultPrefixLabel returns everything up to and including the first slash in the key,
+// so that "twitch/ev/chat-message" is counted under "twitch/"
+func DefaultPrefixLabel(key string) string {
+	if idx := strings.IndexByte(key, '/'); idx >= 0 {
+		return key[:idx+1]
+	}
+	return key
+}
+
+// Collector collects metrics from one or more kilovolt clients
+type Collector struct {
+	prefixLabel func(key string) string
+
+	requestDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+	pushes          *prometheus.CounterVec
+	bufferFill      *prometheus.GaugeVec
+	droppedPushes   *prometheus.CounterVec
+	reconnects      prometheus.Counter
+	bytesSent       prometheus.Counter
+	bytesReceived   prometheus.Counter
+}
+
+// NewCollector creates a new collector, which still needs to be registered
+func NewCollector(options CollectorOptions) *Collector {
+	if options.Namespace == "" {
+		options.Namespace = "kilovolt_client"
+	}
+	if options.PrefixLabel == nil {
+		options.PrefixLabel = DefaultPrefixLabel
+	}
+	if options.Buckets == nil {
+		options.Buckets = prometheus.DefBuckets
+	}
+
+	return &Collector{
+		prefixLabel: options.PrefixLabel,
+
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   options.Namespace,
+			Name:        "request_duration_seconds",
+			Help:        "Time taken by requests to complete, by command and outcome.",
+			ConstLabels: options.ConstLabels,
+			Buckets:     options.Buckets,
+		}, []string{"command", "status"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   options.Namespace,
+			Name:        "requests_in_flight",
+			Help:        "Number of requests waiting for a response.",
+			ConstLabels: options.ConstLabels,
+		}),
+		pushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Name:        "pushes_total",
+			Help:        "Number of pushes received, by key prefix.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"prefix"}),
+		bufferFill: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   options.Namespace,
+			Name:        "subscriber_buffer_fill_ratio",
+			Help:        "How full the subscriber channel was at the last push delivery, by key prefix.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"prefix"}),
+		droppedPushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Name:        "dropped_pushes_total",
+			Help:        "Number of pushes dropped because a subscriber was too slow, by key prefix.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"prefix"}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Name:        "reconnects_total",
+			Help:        "Number of times the connection was reopened.",
+			ConstLabels: options.ConstLabels,
+		}),
+		bytesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Name:        "sent_bytes_total",
+			Help:        "Bytes written to the websocket.",
+			ConstLabels: options.ConstLabels,
+		}),
+		bytesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Name:        "received_bytes_total",
+			Help:        "Bytes read from the websocket.",
+			ConstLabels: options.ConstLabels,
+		}),
+	}
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.requestDuration, c.inFlight, c.pushes, c.bufferFill,
+		c.droppedPushes, c.reconnects, c.bytesSent, c.bytesReceived,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+func (c *Collector) ObserveRequest(command string, duration time.Duration, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	c.requestDuration.WithLabelValues(command, status).Observe(duration.Seconds())
+}
+
+func (c *Collector) AddInFlightRequests(delta int) {
+	c.inFlight.Add(float64(delta))
+}
+
+func (c *Collector) ObservePush(key string) {
+	c.pushes.WithLabelValues(c.prefixLabel(key)).Inc()
+}
+
+func (c *Collector) ObserveSubscriberBuffer(key string, used int, capacity int) {
+	if capacity <= 0 {
+		return
+	}
+	c.bufferFill.WithLabelValues(c.prefixLabel(key)).Set(float64(used) / float64(capacity))
+}
+
+func (c *Collector) ObserveDroppedPush(key string) {
+	c.droppedPushes.WithLabelValues(c.prefixLabel(key)).Inc()
+}
+
+func (c *Collector) ObserveReconnect() {
+	c.reconnects.Inc()
+}
+
+func (c *Collector) AddBytesSent(n int) {
+	c.bytesSent.Add(float64(n))
+}
+
+func (c *Collector) AddBytesReceived(n int) {
+	c.bytesReceived.Add(float64(n))
+}
+
+var (
+	_ kvclient.Metrics     = (*Collector)(nil)
+	_ prometheus.Collector = (*Collector)(nil)
+)
@@ -0,0 +1,113 @@
+package kvprom
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	kv "github.com/strimertul/kilovolt/v11"
+	"go.uber.org/zap"
+
+	kvclient "github.com/strimertul/kilovolt-client-go/v11"
+)
+
+func TestCollector(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server := createInMemoryKV(t, log)
+
+	collector := NewCollector(CollectorOptions{})
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatal("error registering collector", err.Error())
+	}
+
+	client, err := kvclient.NewClient(server.URL, kvclient.ClientOptions{
+		Logger:  kvclient.ZapLogger(log),
+		Metrics: collector,
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	chn, err := client.SubscribePrefix("twitch/")
+	if err != nil {
+		t.Fatal("error subscribing to prefix", err.Error())
+	}
+	if err = client.SetKey("twitch/ev/chat-message", "hello"); err != nil {
+		t.Fatal("error modifying key", err.Error())
+	}
+	select {
+	case <-time.After(20 * time.Second):
+		t.Fatal("push took too long to arrive")
+	case <-chn:
+	}
+
+	if count := testutil.CollectAndCount(collector, "kilovolt_client_request_duration_seconds"); count != 2 {
+		t.Fatalf("expected a histogram for each command, got %d", count)
+	}
+	if value := testutil.ToFloat64(collector.pushes.WithLabelValues("twitch/")); value != 1 {
+		t.Fatalf("wrong push count, expected=1 got=%f", value)
+	}
+	if value := testutil.ToFloat64(collector.inFlight); value != 0 {
+		t.Fatalf("wrong in-flight count, expected=0 got=%f", value)
+	}
+	if value := testutil.ToFloat64(collector.bytesSent); value <= 0 {
+		t.Fatal("no bytes sent recorded")
+	}
+	if value := testutil.ToFloat64(collector.bytesReceived); value <= 0 {
+		t.Fatal("no bytes received recorded")
+	}
+
+	expected := `
+# HELP kilovolt_client_reconnects_total Number of times the connection was reopened.
+# TYPE kilovolt_client_reconnects_total counter
+kilovolt_client_reconnects_total 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "kilovolt_client_reconnects_total"); err != nil {
+		t.Fatal("unexpected metrics output", err.Error())
+	}
+}
+
+func TestCollectorSharedInFlight(t *testing.T) {
+	collector := NewCollector(CollectorOptions{})
+
+	// Two clients with a request each, one of them completes
+	collector.AddInFlightRequests(1)
+	collector.AddInFlightRequests(1)
+	collector.AddInFlightRequests(-1)
+	if value := testutil.ToFloat64(collector.inFlight); value != 1 {
+		t.Fatalf("wrong in-flight count, expected=1 got=%f", value)
+	}
+}
+
+func TestDefaultPrefixLabel(t *testing.T) {
+	for key, expected := range map[string]string{
+		"twitch/ev/chat-message": "twitch/",
+		"stulbe/":                "stulbe/",
+		"noslash":                "noslash",
+	} {
+		if label := DefaultPrefixLabel(key); label != expected {
+			t.Fatalf("wrong prefix label for %s, expected=%s got=%s", key, expected, label)
+		}
+	}
+}
+
+func createInMemoryKV(t *testing.T, log *zap.Logger) *httptest.Server {
+	// Create hub with in-mem DB
+	hub, err := kv.NewHub(kv.MakeBackend(), kv.HubOptions{}, log)
+	if err != nil {
+		t.Fatal("hub initialization failed", err.Error())
+	}
+	go hub.Run()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kv.ServeWs(hub, w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
@@ -0,0 +1,353 @@
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Leases are a best-effort mutual exclusion mechanism built on plain reads and writes.
+//
+// Kilovolt has no compare-and-swap, so acquiring a lease is "check, write, wait, check
+// again": two clients racing for a free lease can both write their value, and the one
+// that wrote last wins once the settle delay has passed. This makes double ownership
+// unlikely but not impossible, specifically:
+//
+//   - A client whose write reaches the server after another client's second check
+//     (e.g. a write delayed by more than the settle delay) will take over the lease
+//     from a holder that already thinks it owns it. The old holder notices through
+//     the Lost channel, but only after the fact.
+//   - Expiry times are compared against the local clock, clients with skewed clocks
+//     may consider a lease expired early (or late).
+//   - A holder that stops renewing (paused process, lost connection) keeps believing
+//     it holds the lease until its own expiry time, while other clients may take it
+//     over as soon as that same time has passed on their clock.
+//
+// Use leases to avoid duplicated work, not where duplicated work would be harmful:
+// check Lost before committing side effects and keep processing idempotent.
+
+var (
+	ErrLeaseHeld = errors.New("lease is held by someone else")
+	ErrLeaseLost = errors.New("lease was lost")
+)
+
+// LeaseOptions configures a lease
+type LeaseOptions struct {
+	// HolderID identifies the lease holder, defaults to a random UUID.
+	// InternalClientID is unique per server but changes every time the client reconnects.
+	HolderID string
+
+	// TTL is how long the lease lasts without being renewed, defaults to 15 seconds
+	TTL time.Duration
+
+	// RenewInterval is how often the lease is renewed, defaults to a third of TTL
+	RenewInterval time.Duration
+
+	// SettleDelay is how long to wait after writing the lease before checking
+	// that no one else overwrote it, defaults to 100 milliseconds
+	SettleDelay time.Duration
+
+	// OnError is called when renewing the lease fails, the lease is lost
+	// if it can't be renewed before it expires
+	OnError func(error)
+}
+
+func (o LeaseOptions) withDefaults() LeaseOptions {
+	if o.HolderID == "" {
+		o.HolderID = UUIDGenerator()()
+	}
+	if o.TTL <= 0 {
+		o.TTL = 15 * time.Second
+	}
+	if o.RenewInterval <= 0 {
+		o.RenewInterval = o.TTL / 3
+	}
+	if o.SettleDelay <= 0 {
+		o.SettleDelay = 100 * time.Millisecond
+	}
+	return o
+}
+
+// LeaseValue is what is stored in the lease key
+type LeaseValue struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// parseLease parses the value of a lease key, empty or invalid values are free leases
+func parseLease(value string) LeaseValue {
+	var lease LeaseValue
+	if value != "" {
+		_ = jsoniter.ConfigFastest.UnmarshalFromString(value, &lease)
+	}
+	return lease
+}
+
+// heldBy returns who holds the lease at the given time, or an empty string if it's free
+func (v LeaseValue) heldBy(now time.Time) string {
+	if v.Holder == "" || !now.Before(v.Expires) {
+		return ""
+	}
+	return v.Holder
+}
+
+// Lease is a lease acquired on a key
+type Lease struct {
+	client  *Client
+	key     string
+	options LeaseOptions
+
+	mu      sync.Mutex
+	expires time.Time
+
+	chn      chan KeyValuePair
+	lost     chan struct{}
+	lostOnce sync.Once
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	unsubscribeOnce sync.Once
+	unsubscribeErr  error
+}
+
+// TryAcquireLease acquires the lease on key, returning ErrLeaseHeld if someone else holds it
+func (s *Client) TryAcquireLease(key string, options LeaseOptions) (*Lease, error) {
+	options = options.withDefaults()
+
+	value, err := s.GetKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if holder := parseLease(value).heldBy(time.Now()); holder != "" && holder != options.HolderID {
+		return nil, ErrLeaseHeld
+	}
+
+	// Subscribe before writing so no competing write can go unnoticed
+	lease := &Lease{
+		client:  s,
+		key:     key,
+		options: options,
+		chn:     make(chan KeyValuePair, 64),
+		lost:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := s.subscribeKey(key, &subscriber{ch: lease.chn}); err != nil {
+		return nil, err
+	}
+
+	if err := lease.write(); err != nil {
+		_ = lease.unsubscribe()
+		return nil, err
+	}
+
+	time.Sleep(options.SettleDelay)
+
+	// Pushes for writes made before this read are delivered before its response,
+	// so what we read is the latest value and the pushes until now are stale
+	value, err = s.GetKey(key)
+	for len(lease.chn) > 0 {
+		<-lease.chn
+	}
+	if err == nil && parseLease(value).Holder != options.HolderID {
+		err = ErrLeaseHeld
+	}
+	if err != nil {
+		_ = lease.unsubscribe()
+		return nil, err
+	}
+
+	lease.wg.Add(1)
+	go lease.run()
+	return lease, nil
+}
+
+// AcquireLease waits until the lease on key is acquired or ctx is done
+func (s *Client) AcquireLease(ctx context.Context, key string, options LeaseOptions) (*Lease, error) {
+	options = options.withDefaults()
+
+	// Nothing reads this channel while trying to acquire the lease, so the read
+	// loop must never block on it: only the latest push is kept
+	chn := make(chan KeyValuePair, 1)
+	if err := s.subscribeKey(key, &subscriber{ch: chn, filter: func(pair KeyValuePair) (KeyValuePair, bool) {
+		select {
+		case <-chn:
+		default:
+		}
+		return pair, true
+	}}); err != nil {
+		return nil, err
+	}
+	defer s.UnsubscribeKey(key, chn)
+
+	for {
+		lease, err := s.TryAcquireLease(key, options)
+		if !errors.Is(err, ErrLeaseHeld) {
+			return lease, err
+		}
+
+		// Wait for the lease to be released or to expire
+		wait := options.TTL
+		value, err := s.GetKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if current := parseLease(value); current.heldBy(time.Now()) != "" {
+			wait = min(wait, time.Until(current.Expires))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-chn:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Key returns the leased key
+func (l *Lease) Key() string {
+	return l.key
+}
+
+// Holder returns the holder ID written in the lease
+func (l *Lease) Holder() string {
+	return l.options.HolderID
+}
+
+// Expires returns when the lease expires unless it's renewed
+func (l *Lease) Expires() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expires
+}
+
+// Lost returns a channel that is closed when the lease is lost, either because
+// someone else took it over or because it couldn't be renewed in time
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops renewing the lease and frees it, returning ErrLeaseLost if it
+// was lost before being released
+func (l *Lease) Release() error {
+	select {
+	case <-l.done:
+		return nil
+	default:
+	}
+	close(l.done)
+	l.wg.Wait()
+	_ = l.unsubscribe()
+
+	select {
+	case <-l.lost:
+		return ErrLeaseLost
+	default:
+	}
+	l.markLost()
+
+	// Don't free the lease if someone else took it in the meantime
+	value, err := l.client.GetKey(l.key)
+	if err != nil {
+		return err
+	}
+	if parseLease(value).Holder != l.options.HolderID {
+		return ErrLeaseLost
+	}
+	return l.client.SetKey(l.key, "")
+}
+
+func (l *Lease) write() error {
+	expires := time.Now().Add(l.options.TTL)
+	value, err := jsoniter.ConfigFastest.MarshalToString(LeaseValue{
+		Holder:  l.options.HolderID,
+		Expires: expires,
+	})
+	if err != nil {
+		return err
+	}
+	if err := l.client.SetKey(l.key, value); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.expires = expires
+	l.mu.Unlock()
+	return nil
+}
+
+// unsubscribe stops watching the lease key, pushes still arriving are discarded
+// so they can't fill the channel once run has stopped reading it
+func (l *Lease) unsubscribe() error {
+	l.unsubscribeOnce.Do(func() {
+		l.unsubscribeErr = unsubscribeDraining(l.chn, func() error {
+			return l.client.UnsubscribeKey(l.key, l.chn)
+		})
+	})
+	return l.unsubscribeErr
+}
+
+func (l *Lease) markLost() {
+	l.lostOnce.Do(func() {
+		close(l.lost)
+	})
+}
+
+// run renews the lease and watches for anyone else writing to it
+func (l *Lease) run() {
+	defer l.wg.Done()
+	// Once lost, whoever holds the lease now keeps writing to the key
+	defer l.unsubscribe()
+
+	ticker := time.NewTicker(l.options.RenewInterval)
+	defer ticker.Stop()
+
+	for {
+		// Stop renewing once our own expiry time has passed
+		expiry := time.NewTimer(time.Until(l.Expires()))
+
+		select {
+		case <-l.done:
+			expiry.Stop()
+			return
+		case push := <-l.chn:
+			if parseLease(push.Value).Holder != l.options.HolderID {
+				expiry.Stop()
+				l.markLost()
+				return
+			}
+		case <-ticker.C:
+			if err := l.renew(); err != nil {
+				if errors.Is(err, ErrLeaseLost) {
+					expiry.Stop()
+					l.markLost()
+					return
+				}
+				if l.options.OnError != nil {
+					l.options.OnError(err)
+				}
+			}
+		case <-expiry.C:
+			l.markLost()
+			return
+		}
+		expiry.Stop()
+	}
+}
+
+func (l *Lease) renew() error {
+	value, err := l.client.GetKey(l.key)
+	if err != nil {
+		return err
+	}
+	if parseLease(value).Holder != l.options.HolderID {
+		return ErrLeaseLost
+	}
+	return l.write()
+}
@@ -0,0 +1,204 @@
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestLease(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	first, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	second, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	options := LeaseOptions{
+		TTL:         300 * time.Millisecond,
+		SettleDelay: 10 * time.Millisecond,
+	}
+
+	t.Run("Exclusive", func(t *testing.T) {
+		lease, err := first.TryAcquireLease("lease/exclusive", LeaseOptions{HolderID: "first", TTL: options.TTL, SettleDelay: options.SettleDelay})
+		if err != nil {
+			t.Fatal("error acquiring lease", err.Error())
+		}
+
+		var stored LeaseValue
+		if err := second.GetJSON("lease/exclusive", &stored); err != nil {
+			t.Fatal("error reading lease", err.Error())
+		}
+		if stored.Holder != "first" || !stored.Expires.After(time.Now()) {
+			t.Fatalf("unexpected lease value: %+v", stored)
+		}
+
+		if _, err := second.TryAcquireLease("lease/exclusive", options); !errors.Is(err, ErrLeaseHeld) {
+			t.Fatal("expected ErrLeaseHeld, got", err)
+		}
+
+		if err := lease.Release(); err != nil {
+			t.Fatal("error releasing lease", err.Error())
+		}
+		other, err := second.TryAcquireLease("lease/exclusive", options)
+		if err != nil {
+			t.Fatal("error acquiring released lease", err.Error())
+		}
+		_ = other.Release()
+	})
+
+	t.Run("Renewal", func(t *testing.T) {
+		lease, err := first.TryAcquireLease("lease/renewal", options)
+		if err != nil {
+			t.Fatal("error acquiring lease", err.Error())
+		}
+		defer lease.Release()
+
+		initial := lease.Expires()
+		time.Sleep(3 * options.TTL)
+
+		select {
+		case <-lease.Lost():
+			t.Fatal("lease was lost while being renewed")
+		default:
+		}
+		if !lease.Expires().After(initial) {
+			t.Fatal("lease was not renewed")
+		}
+		if _, err := second.TryAcquireLease("lease/renewal", options); !errors.Is(err, ErrLeaseHeld) {
+			t.Fatal("expected ErrLeaseHeld, got", err)
+		}
+	})
+
+	t.Run("Takeover", func(t *testing.T) {
+		lease, err := first.TryAcquireLease("lease/takeover", options)
+		if err != nil {
+			t.Fatal("error acquiring lease", err.Error())
+		}
+
+		// Someone ignoring the protocol overwrites the lease
+		if err := second.SetJSON("lease/takeover", LeaseValue{Holder: "intruder", Expires: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal("error writing lease", err.Error())
+		}
+		select {
+		case <-lease.Lost():
+		case <-time.After(time.Second):
+			t.Fatal("lease was not reported as lost")
+		}
+
+		if err := lease.Release(); !errors.Is(err, ErrLeaseLost) {
+			t.Fatal("expected ErrLeaseLost, got", err)
+		}
+		val, _ := second.GetKey("lease/takeover")
+		if parseLease(val).Holder != "intruder" {
+			t.Fatal("releasing a lost lease overwrote the new holder", val)
+		}
+	})
+
+	t.Run("Lost without release", func(t *testing.T) {
+		lease, err := first.TryAcquireLease("lease/lost", options)
+		if err != nil {
+			t.Fatal("error acquiring lease", err.Error())
+		}
+		defer lease.Release()
+
+		if err := second.SetJSON("lease/lost", LeaseValue{Holder: "intruder", Expires: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal("error writing lease", err.Error())
+		}
+		select {
+		case <-lease.Lost():
+		case <-time.After(time.Second):
+			t.Fatal("lease was not reported as lost")
+		}
+
+		// The new holder keeps renewing while the lost lease is never released
+		for i := 0; i < 100; i++ {
+			if err := second.SetJSON("lease/lost", LeaseValue{Holder: "intruder", Expires: time.Now().Add(time.Hour)}); err != nil {
+				t.Fatal("error writing lease", err.Error())
+			}
+		}
+		read := make(chan error, 1)
+		go func() {
+			_, err := first.GetKey("lease/lost")
+			read <- err
+		}()
+		select {
+		case err := <-read:
+			if err != nil {
+				t.Fatal("error reading key", err.Error())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("client stopped responding after losing a lease")
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		crashing, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+		if err != nil {
+			t.Fatal("error creating kv client", err.Error())
+		}
+		lease, err := crashing.TryAcquireLease("lease/expiry", options)
+		if err != nil {
+			t.Fatal("error acquiring lease", err.Error())
+		}
+
+		// The holder goes away without releasing the lease
+		_ = crashing.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		start := time.Now()
+		acquired, err := second.AcquireLease(ctx, "lease/expiry", options)
+		if err != nil {
+			t.Fatal("error waiting for lease", err.Error())
+		}
+		defer acquired.Release()
+		if time.Since(start) < options.TTL/2 {
+			t.Fatal("lease was acquired before expiring")
+		}
+
+		select {
+		case <-lease.Lost():
+		case <-time.After(time.Second):
+			t.Fatal("crashed holder did not notice losing the lease")
+		}
+	})
+
+	t.Run("Wait for release", func(t *testing.T) {
+		lease, err := first.TryAcquireLease("lease/wait", LeaseOptions{TTL: time.Minute, SettleDelay: options.SettleDelay})
+		if err != nil {
+			t.Fatal("error acquiring lease", err.Error())
+		}
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = lease.Release()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		acquired, err := second.AcquireLease(ctx, "lease/wait", options)
+		if err != nil {
+			t.Fatal("error waiting for lease", err.Error())
+		}
+		_ = acquired.Release()
+
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		held, err := first.TryAcquireLease("lease/wait", LeaseOptions{TTL: time.Minute, SettleDelay: options.SettleDelay})
+		if err != nil {
+			t.Fatal("error acquiring lease", err.Error())
+		}
+		defer held.Release()
+		if _, err := second.AcquireLease(ctx, "lease/wait", options); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expected context.DeadlineExceeded, got", err)
+		}
+	})
+}
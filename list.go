@@ -0,0 +1,185 @@
+package kvclient
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrListEmpty = errors.New("list is empty")
+
+// List is an ordered list of strings stored one element per key under a prefix.
+//
+// Elements are keyed by an ID made of their insertion time and a random suffix, so
+// clients can push concurrently without overwriting each other, and removed elements
+// are set to an empty value. Without atomic operations on the server, two clients
+// popping at the same time may both get the same element.
+//
+// Changes can be followed with Subscribe: pushes have the element ID as key and
+// the element as value, or an empty value if the element was removed.
+type List struct {
+	ns *Namespace
+
+	mu     sync.Mutex
+	lastID int64 // Last timestamp used for an element ID, to keep IDs increasing
+}
+
+// ListElement is an element of a List
+type ListElement struct {
+	ID    string
+	Value string
+}
+
+// List returns the list stored under prefix
+func (s *Client) List(prefix string) *List {
+	return &List{ns: s.Namespace(prefix)}
+}
+
+// Prefix returns the prefix the list is stored under
+func (l *List) Prefix() string {
+	return l.ns.Prefix()
+}
+
+func (l *List) nextID() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	if now <= l.lastID {
+		now = l.lastID + 1
+	}
+	l.lastID = now
+
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		panic(fmt.Errorf("could not read random bytes for list ID: %w", err))
+	}
+	// Fixed width hex so IDs sort by time
+	return fmt.Sprintf("%016x-%s", now, hex.EncodeToString(suffix[:]))
+}
+
+// Push appends values to the end of the list
+func (l *List) Push(values ...string) error {
+	if len(values) < 1 {
+		return nil
+	}
+	data := make(map[string]string, len(values))
+	for _, value := range values {
+		if value == "" {
+			return ErrEmptyKey
+		}
+		data[l.nextID()] = value
+	}
+	return l.ns.SetKeys(data)
+}
+
+// Elements returns all elements in order
+func (l *List) Elements() ([]ListElement, error) {
+	values, err := l.ns.GetByPrefix("")
+	if err != nil {
+		return nil, err
+	}
+
+	elements := make([]ListElement, 0, len(values))
+	for id, value := range values {
+		// Removed elements
+		if value == "" {
+			continue
+		}
+		elements = append(elements, ListElement{ID: id, Value: value})
+	}
+	sort.Slice(elements, func(i, j int) bool {
+		return elements[i].ID < elements[j].ID
+	})
+	return elements, nil
+}
+
+// Len returns the number of elements in the list
+func (l *List) Len() (int, error) {
+	elements, err := l.Elements()
+	return len(elements), err
+}
+
+// Range returns the values from index start (inclusive) to stop (exclusive).
+// Negative indexes count from the end of the list, and a stop of 0 means the end
+// of the list: Range(0, 0) returns everything and Range(-10, 0) the last 10 values.
+// Indexes past the bounds of the list are clamped.
+func (l *List) Range(start, stop int) ([]string, error) {
+	elements, err := l.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	if start < 0 {
+		start += len(elements)
+	}
+	if stop <= 0 {
+		stop += len(elements)
+	}
+	start = max(0, min(start, len(elements)))
+	stop = max(start, min(stop, len(elements)))
+
+	values := make([]string, 0, stop-start)
+	for _, element := range elements[start:stop] {
+		values = append(values, element.Value)
+	}
+	return values, nil
+}
+
+// Pop removes and returns the last element, or ErrListEmpty if there are none
+func (l *List) Pop() (string, error) {
+	return l.remove(func(elements []ListElement) ListElement {
+		return elements[len(elements)-1]
+	})
+}
+
+// PopFront removes and returns the first element, or ErrListEmpty if there are none
+func (l *List) PopFront() (string, error) {
+	return l.remove(func(elements []ListElement) ListElement {
+		return elements[0]
+	})
+}
+
+func (l *List) remove(pick func([]ListElement) ListElement) (string, error) {
+	elements, err := l.Elements()
+	if err != nil {
+		return "", err
+	}
+	if len(elements) < 1 {
+		return "", ErrListEmpty
+	}
+
+	element := pick(elements)
+	return element.Value, l.ns.SetKey(element.ID, "")
+}
+
+// Trim removes the oldest elements so that at most n are left
+func (l *List) Trim(n int) error {
+	elements, err := l.Elements()
+	if err != nil {
+		return err
+	}
+	if len(elements) <= n {
+		return nil
+	}
+
+	removed := make(map[string]string)
+	for _, element := range elements[:len(elements)-max(n, 0)] {
+		removed[element.ID] = ""
+	}
+	return l.ns.SetKeys(removed)
+}
+
+// Subscribe returns a channel receiving changes to the list elements
+func (l *List) Subscribe() (chan KeyValuePair, error) {
+	return l.ns.SubscribePrefix("")
+}
+
+// Unsubscribe stops sending changes to a channel returned by Subscribe
+func (l *List) Unsubscribe(chn chan KeyValuePair) error {
+	return l.ns.UnsubscribePrefix("", chn)
+}
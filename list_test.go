@@ -0,0 +1,109 @@
+package kvclient
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestList(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	list := client.List("songs/")
+	changes, err := list.Subscribe()
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+	defer list.Unsubscribe(changes)
+
+	if err := list.Push("one", "two"); err != nil {
+		t.Fatal("error pushing", err.Error())
+	}
+	if err := list.Push("three"); err != nil {
+		t.Fatal("error pushing", err.Error())
+	}
+	// Another client pushing to the same list
+	if err := client.List("songs/").Push("four", "five"); err != nil {
+		t.Fatal("error pushing", err.Error())
+	}
+
+	check := func(start, stop int, expected ...string) {
+		t.Helper()
+		values, err := list.Range(start, stop)
+		if err != nil {
+			t.Fatal("error reading range", err.Error())
+		}
+		if len(expected) == 0 {
+			expected = []string{}
+		}
+		if !reflect.DeepEqual(values, expected) {
+			t.Fatalf("unexpected range [%d:%d], expected=%v got=%v", start, stop, expected, values)
+		}
+	}
+
+	// Values pushed together keep their order too
+	check(0, 0, "one", "two", "three", "four", "five")
+	check(1, 3, "two", "three")
+	check(-2, 0, "four", "five")
+	check(3, 100, "four", "five")
+	check(4, 2)
+
+	for i := 0; i < 5; i++ {
+		select {
+		case change := <-changes:
+			if change.Value == "" || len(change.Key) < 16 {
+				t.Fatalf("unexpected change: %+v", change)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for change")
+		}
+	}
+
+	value, err := list.Pop()
+	if err != nil || value != "five" {
+		t.Fatal("unexpected pop result", value, err)
+	}
+	value, err = list.PopFront()
+	if err != nil || value != "one" {
+		t.Fatal("unexpected pop result", value, err)
+	}
+	check(0, 0, "two", "three", "four")
+
+	select {
+	case change := <-changes:
+		if change.Value != "" {
+			t.Fatalf("expected removal, got %+v", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+
+	if err := list.Trim(1); err != nil {
+		t.Fatal("error trimming", err.Error())
+	}
+	check(0, 0, "four")
+	if n, _ := list.Len(); n != 1 {
+		t.Fatal("unexpected length", n)
+	}
+
+	if err := list.Trim(0); err != nil {
+		t.Fatal("error trimming", err.Error())
+	}
+	if _, err := list.Pop(); !errors.Is(err, ErrListEmpty) {
+		t.Fatal("expected ErrListEmpty, got", err)
+	}
+	if err := list.Push(""); !errors.Is(err, ErrEmptyKey) {
+		t.Fatal("expected ErrEmptyKey, got", err)
+	}
+}
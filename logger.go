@@ -0,0 +1,78 @@
+package kvclient
+
+import (
+	"log/slog"
+
+	"go.uber.org/zap"
+)
+
+// Logger is the logging interface used by the client. Arguments after the
+// message are alternating key/value pairs, as in log/slog.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// LogLevel is the level some of the noisier client logs are written at
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+	// LogNone disables the log entirely
+	LogNone
+)
+
+func (s *Client) logAt(level LogLevel, msg string, args ...any) {
+	switch level {
+	case LogDebug:
+		s.Logger.Debug(msg, args...)
+	case LogInfo:
+		s.Logger.Info(msg, args...)
+	case LogWarn:
+		s.Logger.Warn(msg, args...)
+	case LogError:
+		s.Logger.Error(msg, args...)
+	}
+}
+
+// NopLogger returns a logger that discards everything, this is the default
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// SlogLogger returns a Logger writing to a log/slog logger, or to slog.Default() if nil
+func SlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger
+}
+
+// ZapLogger returns a Logger writing to a zap logger, or discarding everything if nil
+func ZapLogger(logger *zap.Logger) Logger {
+	if logger == nil {
+		return NopLogger()
+	}
+	return zapLogger{logger.WithOptions(zap.AddCallerSkip(1)).Sugar()}
+}
+
+type zapLogger struct {
+	sugar *zap.SugaredLogger
+}
+
+func (z zapLogger) Debug(msg string, args ...any) { z.sugar.Debugw(msg, args...) }
+func (z zapLogger) Info(msg string, args ...any)  { z.sugar.Infow(msg, args...) }
+func (z zapLogger) Warn(msg string, args ...any)  { z.sugar.Warnw(msg, args...) }
+func (z zapLogger) Error(msg string, args ...any) { z.sugar.Errorw(msg, args...) }
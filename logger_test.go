@@ -0,0 +1,89 @@
+package kvclient
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// syncBuffer is a bytes.Buffer that can be written from the read loop while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSlogLogLevels(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	var out syncBuffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger:          SlogLogger(logger),
+		RequestLogLevel: LogInfo,
+		PushLogLevel:    LogNone,
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	chn, err := client.SubscribeKey("logtest")
+	if err != nil {
+		t.Fatal("error subscribing to key", err.Error())
+	}
+	if err = client.SetKey("logtest", "value"); err != nil {
+		t.Fatal("error modifying key", err.Error())
+	}
+	select {
+	case <-time.After(20 * time.Second):
+		t.Fatal("push took too long to arrive")
+	case <-chn:
+	}
+
+	logs := out.String()
+	if !strings.Contains(logs, "msg=\"sent request\"") || !strings.Contains(logs, "cmd=kset") {
+		t.Fatal("request logs missing at configured level", logs)
+	}
+	if strings.Contains(logs, "recv push") {
+		t.Fatal("push logs should be disabled", logs)
+	}
+	if strings.Contains(logs, "connected to ws") {
+		t.Fatal("debug logs should be filtered by the handler", logs)
+	}
+}
+
+func TestDefaultLoggerIsSilent(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	if _, ok := client.Logger.(nopLogger); !ok {
+		t.Fatalf("default logger should be a no-op logger, got %T", client.Logger)
+	}
+}
+
+func TestZapLoggerNil(t *testing.T) {
+	logger := ZapLogger(nil)
+	logger.Error("must not panic")
+}
@@ -0,0 +1,75 @@
+package kvclient
+
+import (
+	"context"
+	"io"
+	"time"
+
+	kv "github.com/strimertul/kilovolt/v11"
+)
+
+// Metrics receives measurements from a client, see kvprom for a Prometheus implementation.
+// Methods are called synchronously from the request and read loop paths, so they
+// must be fast and safe for concurrent use.
+type Metrics interface {
+	// ObserveRequest is called when a request completes, err is nil if it succeeded
+	ObserveRequest(command string, duration time.Duration, err error)
+
+	// AddInFlightRequests is called with +1 when a request starts waiting for a
+	// response and -1 when it stops, so that clients can share an implementation
+	AddInFlightRequests(delta int)
+
+	// ObservePush is called for every push received, before it is delivered
+	ObservePush(key string)
+
+	// ObserveSubscriberBuffer is called before a push is delivered to a subscriber,
+	// with how many pushes are already waiting in its channel
+	ObserveSubscriberBuffer(key string, used int, capacity int)
+
+	// ObserveDroppedPush is called when a push is dropped for a subscriber that
+	// didn't read it within ClientOptions.PushDeliveryTimeout
+	ObserveDroppedPush(key string)
+
+	// ObserveReconnect is called every time the connection is reopened
+	ObserveReconnect()
+
+	// AddBytesSent is called with the size of every message written to the socket
+	AddBytesSent(n int)
+
+	// AddBytesReceived is called with the size of every message read from the socket
+	AddBytesReceived(n int)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveRequest(string, time.Duration, error) {}
+func (nopMetrics) AddInFlightRequests(int)                     {}
+func (nopMetrics) ObservePush(string)                          {}
+func (nopMetrics) ObserveSubscriberBuffer(string, int, int)    {}
+func (nopMetrics) ObserveDroppedPush(string)                   {}
+func (nopMetrics) ObserveReconnect()                           {}
+func (nopMetrics) AddBytesSent(int)                            {}
+func (nopMetrics) AddBytesReceived(int)                        {}
+
+func (s *Client) metricsInterceptor(ctx context.Context, request kv.Request, next Invoker) (kv.Response, error) {
+	start := time.Now()
+	res, err := next(ctx, request)
+	s.metrics.ObserveRequest(request.CmdName, time.Since(start), err)
+	return res, err
+}
+
+func (s *Client) metricsPushInterceptor(push KeyValuePair, next PushHandler) {
+	s.metrics.ObservePush(push.Key)
+	next(push)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
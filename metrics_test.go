@@ -0,0 +1,81 @@
+package kvclient
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type recordingMetrics struct {
+	nopMetrics
+	mu       sync.Mutex
+	requests map[string]int
+	pushes   int
+	dropped  int
+}
+
+func (m *recordingMetrics) ObserveRequest(command string, _ time.Duration, _ error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[command]++
+}
+
+func (m *recordingMetrics) ObservePush(string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pushes++
+}
+
+func (m *recordingMetrics) ObserveDroppedPush(string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped++
+}
+
+func TestDroppedPushMetrics(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	metrics := &recordingMetrics{requests: make(map[string]int)}
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger:              ZapLogger(log),
+		Metrics:             metrics,
+		PushDeliveryTimeout: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	// Never read from this channel so its buffer fills up
+	chn, err := client.SubscribeKey("flood")
+	if err != nil {
+		t.Fatal("error subscribing to key", err.Error())
+	}
+	total := cap(chn) + 2
+	for i := 0; i < total; i++ {
+		if err = client.SetKey("flood", fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatal("error modifying key", err.Error())
+		}
+	}
+
+	// Wait for all pushes to be processed
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		metrics.mu.Lock()
+		pushes, dropped, writes := metrics.pushes, metrics.dropped, metrics.requests["kset"]
+		metrics.mu.Unlock()
+		if pushes == total && dropped == 2 {
+			if writes != total {
+				t.Fatalf("wrong number of requests recorded, expected=%d got=%d", total, writes)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pushes took too long to process, got %d of %d (%d dropped, expected 2)", pushes, total, dropped)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
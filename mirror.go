@@ -0,0 +1,341 @@
+package kvclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrBidirectionalRewrite = errors.New("bidirectional mirrors cannot rewrite keys")
+
+// maxPendingEchoes is how many written values per key a bidirectional mirror
+// remembers while waiting for their push to come back
+const maxPendingEchoes = 16
+
+// MirrorOptions configures a Mirror
+type MirrorOptions struct {
+	// Prefix of the keys to mirror, empty mirrors everything
+	Prefix string
+
+	// Filter, if set, decides which source keys are mirrored
+	Filter func(key string) bool
+
+	// Rewrite, if set, maps a source key to the destination key (one-way mirrors only)
+	Rewrite func(key string) string
+
+	// OnError is called when a change can't be written to the destination,
+	// the mirror keeps going with the next change
+	OnError func(error)
+}
+
+// MirrorStats reports the progress of a Mirror
+type MirrorStats struct {
+	Copied      int           // Keys written by the initial copy
+	Applied     uint64        // Changes written since the initial copy
+	Skipped     uint64        // Pushes ignored because they were echoes of the mirror's own writes
+	Errors      uint64        // Changes that failed to be written
+	Pending     int           // Changes received but not written yet
+	Lag         time.Duration // Time the last applied change waited between being received and being written
+	LastApplied time.Time     // When the last change was written
+}
+
+// Mirror replicates keys from one client to another as they change.
+//
+// Changes are picked up from pushes, so anything written while the mirror (or the
+// connection) is down is only picked up by starting a new mirror.
+type Mirror struct {
+	options MirrorOptions
+	links   []*mirrorLink
+
+	mu    sync.Mutex // Guards stats and echoes
+	stats MirrorStats
+
+	wg sync.WaitGroup
+}
+
+// mirrorLink is one direction of a mirror
+type mirrorLink struct {
+	mirror   *Mirror
+	src, dst *Client
+	chn      chan KeyValuePair
+
+	// In bidirectional mirrors, the link writing into our source
+	reverse *mirrorLink
+	// Values written to dst whose push hasn't come back yet, by key
+	echoes map[string][]string
+
+	// Pushes received but not applied yet
+	qmu    sync.Mutex
+	qcond  *sync.Cond
+	queue  []mirrorChange
+	closed bool
+	done   chan struct{}
+
+	// Serializes writes to dst between the initial copy and pushes
+	wmu     sync.Mutex
+	applied map[string]struct{} // Keys written from pushes, used to avoid overwriting them with stale copies
+}
+
+type mirrorChange struct {
+	KeyValuePair
+	received time.Time
+}
+
+// NewMirror copies all keys matching the options from src to dst and keeps
+// following changes until the mirror is closed
+func NewMirror(src, dst *Client, options MirrorOptions) (*Mirror, error) {
+	mirror := &Mirror{options: options}
+	link := mirror.link(src, dst)
+
+	if err := link.start(); err != nil {
+		return nil, err
+	}
+	if err := link.copy(); err != nil {
+		_ = mirror.Close()
+		return nil, err
+	}
+	return mirror, nil
+}
+
+// NewBidirectionalMirror keeps keys matching the options in sync between a and b.
+// The initial copy goes from a to b, after that changes on either side are written
+// to the other. Writes are not ordered across the two servers: if the same key is
+// changed on both sides at the same time, they may end up with different values.
+func NewBidirectionalMirror(a, b *Client, options MirrorOptions) (*Mirror, error) {
+	if options.Rewrite != nil {
+		return nil, ErrBidirectionalRewrite
+	}
+
+	mirror := &Mirror{options: options}
+	forward := mirror.link(a, b)
+	backward := mirror.link(b, a)
+	forward.reverse = backward
+	backward.reverse = forward
+
+	for _, link := range mirror.links {
+		if err := link.start(); err != nil {
+			_ = mirror.Close()
+			return nil, err
+		}
+	}
+	if err := forward.copy(); err != nil {
+		_ = mirror.Close()
+		return nil, err
+	}
+	return mirror, nil
+}
+
+func (m *Mirror) link(src, dst *Client) *mirrorLink {
+	link := &mirrorLink{
+		mirror:  m,
+		src:     src,
+		dst:     dst,
+		echoes:  make(map[string][]string),
+		applied: make(map[string]struct{}),
+		done:    make(chan struct{}),
+	}
+	link.qcond = sync.NewCond(&link.qmu)
+	m.links = append(m.links, link)
+	return link
+}
+
+// Stats returns the current mirror statistics
+func (m *Mirror) Stats() MirrorStats {
+	m.mu.Lock()
+	stats := m.stats
+	m.mu.Unlock()
+
+	for _, link := range m.links {
+		link.qmu.Lock()
+		stats.Pending += len(link.queue)
+		link.qmu.Unlock()
+	}
+	return stats
+}
+
+// Close stops following changes, changes not written yet are discarded
+func (m *Mirror) Close() error {
+	var err error
+	for _, link := range m.links {
+		if link.chn == nil {
+			continue
+		}
+		if unsubErr := link.src.UnsubscribePrefix(link.mirror.options.Prefix, link.chn); unsubErr != nil && err == nil {
+			err = unsubErr
+		}
+
+		link.qmu.Lock()
+		if !link.closed {
+			link.closed = true
+			close(link.done)
+		}
+		link.qcond.Broadcast()
+		link.qmu.Unlock()
+	}
+	m.wg.Wait()
+	return err
+}
+
+// start subscribes to the source and starts applying changes
+func (l *mirrorLink) start() error {
+	var err error
+	l.chn, err = l.src.SubscribePrefix(l.mirror.options.Prefix)
+	if err != nil {
+		return err
+	}
+
+	l.mirror.wg.Add(2)
+	go l.receive()
+	go l.apply()
+	return nil
+}
+
+// receive moves pushes into the queue so that a slow destination never blocks the source read loop
+func (l *mirrorLink) receive() {
+	defer l.mirror.wg.Done()
+	for {
+		select {
+		case <-l.done:
+			return
+		case kv := <-l.chn:
+			l.qmu.Lock()
+			l.queue = append(l.queue, mirrorChange{KeyValuePair: kv, received: time.Now()})
+			l.qcond.Signal()
+			l.qmu.Unlock()
+		}
+	}
+}
+
+func (l *mirrorLink) apply() {
+	defer l.mirror.wg.Done()
+	for {
+		l.qmu.Lock()
+		for len(l.queue) < 1 && !l.closed {
+			l.qcond.Wait()
+		}
+		if l.closed {
+			l.qmu.Unlock()
+			return
+		}
+		change := l.queue[0]
+		l.queue = l.queue[1:]
+		l.qmu.Unlock()
+
+		l.wmu.Lock()
+		key, ok := l.target(change.Key)
+		if ok && l.isEcho(change.KeyValuePair) {
+			ok = false
+			l.mirror.mu.Lock()
+			l.mirror.stats.Skipped++
+			l.mirror.mu.Unlock()
+		}
+		if ok {
+			l.applied[key] = struct{}{}
+			l.write(map[string]string{key: change.Value})
+			l.mirror.mu.Lock()
+			l.mirror.stats.Applied++
+			l.mirror.stats.LastApplied = time.Now()
+			l.mirror.stats.Lag = l.mirror.stats.LastApplied.Sub(change.received)
+			l.mirror.mu.Unlock()
+		}
+		l.wmu.Unlock()
+	}
+}
+
+// copy writes the current source values to the destination
+func (l *mirrorLink) copy() error {
+	values, err := l.src.GetByPrefix(l.mirror.options.Prefix)
+	if err != nil {
+		return err
+	}
+
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+
+	toSet := make(map[string]string)
+	for srcKey, value := range values {
+		key, ok := l.target(srcKey)
+		if !ok {
+			continue
+		}
+		// Changes pushed after we read the values are newer, don't overwrite them
+		if _, ok := l.applied[key]; ok {
+			continue
+		}
+		toSet[key] = value
+	}
+	if len(toSet) < 1 {
+		return nil
+	}
+
+	l.recordEchoes(toSet)
+	if err := l.dst.SetKeys(toSet); err != nil {
+		return err
+	}
+
+	l.mirror.mu.Lock()
+	l.mirror.stats.Copied += len(toSet)
+	l.mirror.mu.Unlock()
+	return nil
+}
+
+// target returns the destination key for a source key, or false if the key is not mirrored
+func (l *mirrorLink) target(key string) (string, bool) {
+	if l.mirror.options.Filter != nil && !l.mirror.options.Filter(key) {
+		return "", false
+	}
+	if l.mirror.options.Rewrite != nil {
+		return l.mirror.options.Rewrite(key), true
+	}
+	return key, true
+}
+
+func (l *mirrorLink) write(data map[string]string) {
+	l.recordEchoes(data)
+	if err := l.dst.SetKeys(data); err != nil {
+		l.mirror.mu.Lock()
+		l.mirror.stats.Errors++
+		l.mirror.mu.Unlock()
+		if l.mirror.options.OnError != nil {
+			l.mirror.options.OnError(err)
+		}
+	}
+}
+
+// recordEchoes remembers values about to be written, so the reverse link can
+// recognize their pushes and not send them back where they came from
+func (l *mirrorLink) recordEchoes(data map[string]string) {
+	if l.reverse == nil {
+		return
+	}
+	l.mirror.mu.Lock()
+	defer l.mirror.mu.Unlock()
+	for key, value := range data {
+		pending := append(l.echoes[key], value)
+		if len(pending) > maxPendingEchoes {
+			pending = pending[len(pending)-maxPendingEchoes:]
+		}
+		l.echoes[key] = pending
+	}
+}
+
+// isEcho checks (and forgets) if a push is the result of a write made by the reverse link
+func (l *mirrorLink) isEcho(push KeyValuePair) bool {
+	if l.reverse == nil {
+		return false
+	}
+	l.mirror.mu.Lock()
+	defer l.mirror.mu.Unlock()
+	pending := l.reverse.echoes[push.Key]
+	for i, value := range pending {
+		if value == push.Value {
+			if len(pending) == 1 {
+				delete(l.reverse.echoes, push.Key)
+			} else {
+				l.reverse.echoes[push.Key] = append(pending[:i:i], pending[i+1:]...)
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package kvclient
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMirror(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	primaryServer, _ := createInMemoryKV(t, log)
+	backupServer, _ := createInMemoryKV(t, log)
+
+	primary, err := NewClient(primaryServer.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	backup, err := NewClient(backupServer.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	if err := primary.SetKeys(map[string]string{
+		"bot/config":  "initial",
+		"bot/secret":  "hidden",
+		"other/thing": "ignored",
+	}); err != nil {
+		t.Fatal("error setting keys", err.Error())
+	}
+
+	mirror, err := NewMirror(primary, backup, MirrorOptions{
+		Prefix: "bot/",
+		Filter: func(key string) bool {
+			return key != "bot/secret"
+		},
+		Rewrite: func(key string) string {
+			return "backup/" + strings.TrimPrefix(key, "bot/")
+		},
+	})
+	if err != nil {
+		t.Fatal("error starting mirror", err.Error())
+	}
+	defer mirror.Close()
+
+	if stats := mirror.Stats(); stats.Copied != 1 {
+		t.Fatalf("unexpected stats after initial copy: %+v", stats)
+	}
+	val, _ := backup.GetKey("backup/config")
+	if val != "initial" {
+		t.Fatal("initial copy did not write rewritten key", val)
+	}
+
+	if err := primary.SetKey("bot/config", "changed"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	if err := primary.SetKey("bot/secret", "changed"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	waitFor(t, "change to be mirrored", func() bool {
+		val, _ := backup.GetKey("backup/config")
+		return val == "changed"
+	})
+
+	stats := mirror.Stats()
+	if stats.Applied != 1 || stats.LastApplied.IsZero() || stats.Lag < 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	keys, _ := backup.ListKeys("")
+	if len(keys) != 1 {
+		t.Fatal("filtered keys were mirrored", keys)
+	}
+
+	if err := mirror.Close(); err != nil {
+		t.Fatal("error closing mirror", err.Error())
+	}
+	if err := primary.SetKey("bot/config", "after close"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	time.Sleep(50 * time.Millisecond)
+	val, _ = backup.GetKey("backup/config")
+	if val != "changed" {
+		t.Fatal("change was mirrored after close", val)
+	}
+}
+
+func TestBidirectionalMirror(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	serverA, _ := createInMemoryKV(t, log)
+	serverB, _ := createInMemoryKV(t, log)
+
+	a, err := NewClient(serverA.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	b, err := NewClient(serverB.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	if _, err := NewBidirectionalMirror(a, b, MirrorOptions{Rewrite: strings.ToUpper}); !errors.Is(err, ErrBidirectionalRewrite) {
+		t.Fatal("expected ErrBidirectionalRewrite, got", err)
+	}
+
+	if err := a.SetKey("sync/a", "from a"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	mirror, err := NewBidirectionalMirror(a, b, MirrorOptions{Prefix: "sync/"})
+	if err != nil {
+		t.Fatal("error starting mirror", err.Error())
+	}
+	defer mirror.Close()
+
+	if err := b.SetKey("sync/b", "from b"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	for i, value := range []string{"1", "2", "3"} {
+		client := a
+		if i%2 == 1 {
+			client = b
+		}
+		if err := client.SetKey("sync/counter", value); err != nil {
+			t.Fatal("error setting key", err.Error())
+		}
+		waitFor(t, "both sides to agree", func() bool {
+			va, _ := a.GetKey("sync/counter")
+			vb, _ := b.GetKey("sync/counter")
+			return va == value && vb == value
+		})
+	}
+
+	waitFor(t, "echoes to settle", func() bool {
+		// Every write, including the initial copy, comes back once as a push
+		stats := mirror.Stats()
+		return stats.Skipped == stats.Applied+uint64(stats.Copied)
+	})
+	applied := mirror.Stats().Applied
+	time.Sleep(100 * time.Millisecond)
+
+	stats := mirror.Stats()
+	if stats.Applied != applied || stats.Applied != 4 {
+		t.Fatalf("changes kept bouncing between servers: %+v", stats)
+	}
+	for _, client := range []*Client{a, b} {
+		values, _ := client.GetByPrefix("sync/")
+		if values["sync/a"] != "from a" || values["sync/b"] != "from b" {
+			t.Fatal("servers are out of sync", values)
+		}
+	}
+}
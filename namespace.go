@@ -0,0 +1,138 @@
+package kvclient
+
+import (
+	"strings"
+)
+
+// Namespace is a view of a client where all keys are relative to a prefix.
+// The prefix is prepended to keys in requests and stripped from keys in
+// responses and pushes, so code using a namespace never sees it.
+type Namespace struct {
+	client *Client
+	prefix string
+}
+
+// Namespace returns a view of the client where all keys are relative to prefix
+func (s *Client) Namespace(prefix string) *Namespace {
+	return &Namespace{client: s, prefix: prefix}
+}
+
+// Namespace returns a nested view, with prefix appended to the current one
+func (n *Namespace) Namespace(prefix string) *Namespace {
+	return &Namespace{client: n.client, prefix: n.prefix + prefix}
+}
+
+// Prefix returns the full prefix of the namespace
+func (n *Namespace) Prefix() string {
+	return n.prefix
+}
+
+// Client returns the underlying client
+func (n *Namespace) Client() *Client {
+	return n.client
+}
+
+func (n *Namespace) key(key string) string {
+	return n.prefix + key
+}
+
+func (n *Namespace) strip(key string) string {
+	return strings.TrimPrefix(key, n.prefix)
+}
+
+func (n *Namespace) stripMap(data map[string]string) map[string]string {
+	result := make(map[string]string, len(data))
+	for k, v := range data {
+		result[n.strip(k)] = v
+	}
+	return result
+}
+
+func (n *Namespace) subscriber(chn chan KeyValuePair, options []SubscribeOption) *subscriber {
+	return newSubscriber(chn, func(pair KeyValuePair) (KeyValuePair, bool) {
+		pair.Key = n.strip(pair.Key)
+		return pair, true
+	}, options)
+}
+
+func (n *Namespace) GetKey(key string) (string, error) {
+	return n.client.GetKey(n.key(key))
+}
+
+func (n *Namespace) GetKeys(keys []string) (map[string]string, error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = n.key(key)
+	}
+
+	data, err := n.client.GetKeys(prefixed)
+	if err != nil {
+		return nil, err
+	}
+	return n.stripMap(data), nil
+}
+
+func (n *Namespace) GetByPrefix(prefix string) (map[string]string, error) {
+	data, err := n.client.GetByPrefix(n.key(prefix))
+	if err != nil {
+		return nil, err
+	}
+	return n.stripMap(data), nil
+}
+
+func (n *Namespace) GetJSON(key string, dst interface{}) error {
+	return n.client.GetJSON(n.key(key), dst)
+}
+
+func (n *Namespace) SetKey(key string, data string) error {
+	return n.client.SetKey(n.key(key), data)
+}
+
+func (n *Namespace) SetKeys(data map[string]string) error {
+	prefixed := make(map[string]string, len(data))
+	for k, v := range data {
+		prefixed[n.key(k)] = v
+	}
+	return n.client.SetKeys(prefixed)
+}
+
+func (n *Namespace) SetJSON(key string, data interface{}) error {
+	return n.client.SetJSON(n.key(key), data)
+}
+
+func (n *Namespace) SetJSONs(data map[string]interface{}) error {
+	prefixed := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		prefixed[n.key(k)] = v
+	}
+	return n.client.SetJSONs(prefixed)
+}
+
+func (n *Namespace) SubscribeKey(key string, options ...SubscribeOption) (chan KeyValuePair, error) {
+	chn := make(chan KeyValuePair, 10)
+	return chn, n.client.subscribeKey(n.key(key), n.subscriber(chn, options))
+}
+
+func (n *Namespace) UnsubscribeKey(key string, chn chan KeyValuePair) error {
+	return n.client.UnsubscribeKey(n.key(key), chn)
+}
+
+func (n *Namespace) SubscribePrefix(prefix string, options ...SubscribeOption) (chan KeyValuePair, error) {
+	chn := make(chan KeyValuePair, 10)
+	return chn, n.client.subscribePrefix(n.key(prefix), n.subscriber(chn, options))
+}
+
+func (n *Namespace) UnsubscribePrefix(prefix string, chn chan KeyValuePair) error {
+	return n.client.UnsubscribePrefix(n.key(prefix), chn)
+}
+
+func (n *Namespace) ListKeys(prefix string) ([]string, error) {
+	keys, err := n.client.ListKeys(n.key(prefix))
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = n.strip(key)
+	}
+	return keys, nil
+}
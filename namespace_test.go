@@ -0,0 +1,141 @@
+package kvclient
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestNamespace(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	twitch := client.Namespace("twitch/")
+	events := twitch.Namespace("ev/")
+	if events.Prefix() != "twitch/ev/" {
+		t.Fatal("wrong nested prefix", events.Prefix())
+	}
+
+	t.Run("SetKey", func(t *testing.T) {
+		if err := events.SetKey("chat-message", "hello"); err != nil {
+			t.Fatal("error setting key", err.Error())
+		}
+		val, err := client.GetKey("twitch/ev/chat-message")
+		if err != nil {
+			t.Fatal("error getting key", err.Error())
+		}
+		if val != "hello" {
+			t.Fatalf("returned value is different than expected, expected=%s got=%s", "hello", val)
+		}
+	})
+	t.Run("GetKey", func(t *testing.T) {
+		val, err := twitch.GetKey("ev/chat-message")
+		if err != nil {
+			t.Fatal("error getting key", err.Error())
+		}
+		if val != "hello" {
+			t.Fatalf("returned value is different than expected, expected=%s got=%s", "hello", val)
+		}
+	})
+	t.Run("SetKeys", func(t *testing.T) {
+		if err := events.SetKeys(map[string]string{"follow": "1", "raid": "2"}); err != nil {
+			t.Fatal("error setting multiple keys", err.Error())
+		}
+	})
+	t.Run("GetKeys", func(t *testing.T) {
+		vals, err := events.GetKeys([]string{"follow", "raid"})
+		if err != nil {
+			t.Fatal("error getting multiple keys", err.Error())
+		}
+		if vals["follow"] != "1" || vals["raid"] != "2" {
+			t.Fatal("wrong values returned", vals)
+		}
+	})
+	t.Run("GetByPrefix", func(t *testing.T) {
+		vals, err := twitch.GetByPrefix("ev/")
+		if err != nil {
+			t.Fatal("error getting keys by prefix", err.Error())
+		}
+		if len(vals) != 3 || vals["ev/chat-message"] != "hello" {
+			t.Fatal("wrong values returned", vals)
+		}
+	})
+	t.Run("ListKeys", func(t *testing.T) {
+		keys, err := events.ListKeys("")
+		if err != nil {
+			t.Fatal("error listing keys", err.Error())
+		}
+		if len(keys) != 3 || keys[0] != "chat-message" || keys[1] != "follow" || keys[2] != "raid" {
+			t.Fatal("wrong keys returned", keys)
+		}
+	})
+	t.Run("JSON", func(t *testing.T) {
+		if err := events.SetJSON("json", map[string]int{"value": 1234}); err != nil {
+			t.Fatal("error setting JSON key", err.Error())
+		}
+		var result map[string]int
+		if err := events.GetJSON("json", &result); err != nil {
+			t.Fatal("error getting JSON key", err.Error())
+		}
+		if result["value"] != 1234 {
+			t.Fatal("wrong value returned", result)
+		}
+	})
+}
+
+func TestNamespaceSubscriptions(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	events := client.Namespace("twitch/").Namespace("ev/")
+
+	keyChn, err := events.SubscribeKey("redeem")
+	if err != nil {
+		t.Fatal("error subscribing to key", err.Error())
+	}
+	prefixChn, err := events.SubscribePrefix("")
+	if err != nil {
+		t.Fatal("error subscribing to prefix", err.Error())
+	}
+	// A raw subscription on the same key must still see the full key
+	rawChn, err := client.SubscribeKey("twitch/ev/redeem")
+	if err != nil {
+		t.Fatal("error subscribing to key", err.Error())
+	}
+
+	if err = client.SetKey("twitch/ev/redeem", "value"); err != nil {
+		t.Fatal("error modifying key", err.Error())
+	}
+
+	for chn, expected := range map[chan KeyValuePair]string{keyChn: "redeem", prefixChn: "redeem", rawChn: "twitch/ev/redeem"} {
+		select {
+		case <-time.After(20 * time.Second):
+			t.Fatal("push took too long to arrive")
+		case push := <-chn:
+			if push.Key != expected || push.Value != "value" {
+				t.Fatal("wrong value received", push)
+			}
+		}
+	}
+
+	if err = events.UnsubscribeKey("redeem", keyChn); err != nil {
+		t.Fatal("error unsubscribing from key", err.Error())
+	}
+	if err = events.UnsubscribePrefix("", prefixChn); err != nil {
+		t.Fatal("error unsubscribing from prefix", err.Error())
+	}
+}
@@ -0,0 +1,220 @@
+package kvclient
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+)
+
+// regexPatternPrefix marks patterns that are regular expressions instead of globs
+const regexPatternPrefix = "re:"
+
+// Pattern matches keys with a glob or a regular expression, see ParsePattern
+type Pattern struct {
+	source string
+	re     *regexp.Regexp
+	prefix string
+}
+
+// ParsePattern parses a key pattern. Patterns starting with "re:" are regular
+// expressions (see regexp/syntax) that must match the whole key, the others are
+// globs where "*" matches any sequence of characters except "/", "**" matches any
+// sequence of characters including "/", "?" matches a single character except "/"
+// and "[...]" matches a character class ("[^...]" or "[!...]" to negate it).
+// Use "\" to match any of these literally.
+func ParsePattern(pattern string) (*Pattern, error) {
+	var prefix string
+	expr, ok := strings.CutPrefix(pattern, regexPatternPrefix)
+	if ok {
+		parsed, err := syntax.Parse(expr, syntax.Perl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		prefix, _ = regexLiteralPrefix(parsed.Simplify())
+	} else {
+		var err error
+		if expr, prefix, err = globToRegex(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	re, err := regexp.Compile(`^(?:` + expr + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return &Pattern{source: pattern, re: re, prefix: prefix}, nil
+}
+
+// MustParsePattern is like ParsePattern but panics if the pattern is invalid
+func MustParsePattern(pattern string) *Pattern {
+	p, err := ParsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Match returns true if key matches the pattern
+func (p *Pattern) Match(key string) bool {
+	return strings.HasPrefix(key, p.prefix) && p.re.MatchString(key)
+}
+
+// Prefix returns the longest literal prefix shared by all keys matching the pattern
+func (p *Pattern) Prefix() string {
+	return p.prefix
+}
+
+func (p *Pattern) String() string {
+	return p.source
+}
+
+// globToRegex converts a glob to a regular expression, also returning the
+// literal characters before the first wildcard
+func globToRegex(glob string) (string, string, error) {
+	var expr, prefix strings.Builder
+	literal := true
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			literal = false
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			literal = false
+			expr.WriteString("[^/]")
+		case '[':
+			literal = false
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return "", "", fmt.Errorf("unterminated character class")
+			}
+			class := glob[i+1 : i+1+end]
+			if class != "" && (class[0] == '^' || class[0] == '!') {
+				class = "^/" + class[1:]
+			}
+			if class == "" || class == "^/" {
+				return "", "", fmt.Errorf("empty character class")
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 >= len(glob) {
+				return "", "", fmt.Errorf("trailing escape")
+			}
+			i++
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			if literal {
+				prefix.WriteByte(glob[i])
+			}
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+			if literal {
+				prefix.WriteByte(c)
+			}
+		}
+	}
+	return expr.String(), prefix.String(), nil
+}
+
+// regexLiteralPrefix returns the literal text every match of re starts with,
+// and whether that text is all re can match
+func regexLiteralPrefix(re *syntax.Regexp) (string, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return "", false
+		}
+		return string(re.Rune), true
+	case syntax.OpEmptyMatch, syntax.OpBeginText:
+		return "", true
+	case syntax.OpCapture:
+		return regexLiteralPrefix(re.Sub[0])
+	case syntax.OpConcat:
+		var prefix strings.Builder
+		for _, sub := range re.Sub {
+			literal, complete := regexLiteralPrefix(sub)
+			prefix.WriteString(literal)
+			if !complete {
+				return prefix.String(), false
+			}
+		}
+		return prefix.String(), true
+	default:
+		return "", false
+	}
+}
+
+// patternSubscriptions remembers which prefix each pattern subscription was added to
+type patternSubscriptions struct {
+	mu       sync.Mutex
+	prefixes map[chan KeyValuePair]string
+}
+
+// SubscribePattern subscribes to all keys matching a pattern (see ParsePattern).
+// The server is asked to push changes under the pattern's literal prefix (or an
+// existing subscription covering it is reused) and pushes are filtered client-side,
+// so patterns with a short literal prefix, like "**/redeem", can be expensive.
+// See SubscribeKey for options.
+func (s *Client) SubscribePattern(pattern string, options ...SubscribeOption) (chan KeyValuePair, error) {
+	p, err := ParsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	chn := make(chan KeyValuePair, 10)
+	prefix := s.coveringPrefix(p.Prefix())
+
+	s.patternsubs.mu.Lock()
+	if s.patternsubs.prefixes == nil {
+		s.patternsubs.prefixes = make(map[chan KeyValuePair]string)
+	}
+	s.patternsubs.prefixes[chn] = prefix
+	s.patternsubs.mu.Unlock()
+
+	err = s.subscribePrefix(prefix, newSubscriber(chn, func(pair KeyValuePair) (KeyValuePair, bool) {
+		return pair, p.Match(pair.Key)
+	}, options))
+	if err != nil {
+		s.patternsubs.mu.Lock()
+		delete(s.patternsubs.prefixes, chn)
+		s.patternsubs.mu.Unlock()
+		_ = s.UnsubscribePrefix(prefix, chn)
+		return nil, err
+	}
+	return chn, nil
+}
+
+// UnsubscribePattern removes a subscription created by SubscribePattern
+func (s *Client) UnsubscribePattern(chn chan KeyValuePair) error {
+	s.patternsubs.mu.Lock()
+	prefix, ok := s.patternsubs.prefixes[chn]
+	delete(s.patternsubs.prefixes, chn)
+	s.patternsubs.mu.Unlock()
+
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	return s.UnsubscribePrefix(prefix, chn)
+}
+
+// coveringPrefix returns the shortest prefix with active subscribers that
+// covers prefix, or prefix itself if there is none
+func (s *Client) coveringPrefix(prefix string) string {
+	covering := prefix
+	found := false
+	for pair := range s.prefixsubs.IterBuffered() {
+		if len(pair.Val.([]*subscriber)) < 1 || !strings.HasPrefix(prefix, pair.Key) {
+			continue
+		}
+		if !found || len(pair.Key) < len(covering) {
+			covering, found = pair.Key, true
+		}
+	}
+	return covering
+}
@@ -0,0 +1,141 @@
+package kvclient
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		prefix  string
+		match   []string
+		noMatch []string
+	}{
+		{"twitch/ev/*/redeem", "twitch/ev/", []string{"twitch/ev/ash/redeem", "twitch/ev//redeem"}, []string{"twitch/ev/a/b/redeem", "twitch/ev/ash/redeem/x"}},
+		{"twitch/**", "twitch/", []string{"twitch/", "twitch/ev/ash/redeem"}, []string{"twitch", "youtube/ev"}},
+		{"twitch/**/redeem", "twitch/", []string{"twitch/ev/redeem", "twitch/ev/ash/redeem"}, []string{"twitch/redeem", "twitch/ev/cheer"}},
+		{"**/redeem", "", []string{"a/redeem", "a/b/redeem"}, []string{"redeem/a"}},
+		{"chat/?", "chat/", []string{"chat/a"}, []string{"chat/", "chat/ab", "chat//"}},
+		{"user/[abc]*", "user/", []string{"user/ash", "user/brock"}, []string{"user/misty"}},
+		{"user/[!a]", "user/", []string{"user/b"}, []string{"user/a", "user//"}},
+		{`literal\*.json`, "literal*.json", []string{"literal*.json"}, []string{"literalx.json"}},
+		{"a.b+c", "a.b+c", []string{"a.b+c"}, []string{"axb+c", "a.bbc"}},
+		{"re:twitch/.*/redeem", "twitch/", []string{"twitch/ev/redeem"}, []string{"youtube/ev/redeem"}},
+		{"re:(?i)twitch/.*", "", []string{"Twitch/ev"}, []string{"youtube/ev"}},
+		{"re:twitch/ev/[0-9]+/(redeem|cheer)", "twitch/ev/", []string{"twitch/ev/12/cheer"}, []string{"twitch/ev/ash/cheer", "twitch/ev/12/cheerx"}},
+	}
+	for _, test := range tests {
+		p, err := ParsePattern(test.pattern)
+		if err != nil {
+			t.Fatal("error parsing pattern", test.pattern, err.Error())
+		}
+		if p.Prefix() != test.prefix {
+			t.Errorf("wrong prefix for %s, expected=%q got=%q", test.pattern, test.prefix, p.Prefix())
+		}
+		for _, key := range test.match {
+			if !p.Match(key) {
+				t.Errorf("%s should match %s", test.pattern, key)
+			}
+		}
+		for _, key := range test.noMatch {
+			if p.Match(key) {
+				t.Errorf("%s should not match %s", test.pattern, key)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"[abc", "[]", `trailing\`, "re:("} {
+		if _, err := ParsePattern(invalid); err == nil {
+			t.Error("invalid pattern was parsed", invalid)
+		}
+	}
+}
+
+func TestSubscribePattern(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	redeems, err := client.SubscribePattern("twitch/ev/*/redeem")
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+	cheers, err := client.SubscribePattern("re:twitch/ev/[a-z]+/cheer")
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+	// Both patterns share the same server subscription
+	if keys := client.prefixsubs.Keys(); len(keys) != 1 || keys[0] != "twitch/ev/" {
+		t.Fatal("unexpected server subscriptions", keys)
+	}
+
+	if _, err := client.SubscribePattern("[nope"); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+
+	for _, key := range []string{"twitch/ev/ash/follow", "twitch/ev/ash/redeem", "twitch/ev/a/b/redeem", "twitch/ev/misty/cheer", "twitch/ev/42/cheer"} {
+		if err := client.SetKey(key, "1"); err != nil {
+			t.Fatal("error setting key", err.Error())
+		}
+	}
+
+	expect := func(chn chan KeyValuePair, key string) {
+		t.Helper()
+		select {
+		case push := <-chn:
+			if push.Key != key {
+				t.Fatal("unexpected push", push)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for", key)
+		}
+		select {
+		case push := <-chn:
+			t.Fatal("unexpected push", push)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	expect(redeems, "twitch/ev/ash/redeem")
+	expect(cheers, "twitch/ev/misty/cheer")
+
+	if err := client.UnsubscribePattern(redeems); err != nil {
+		t.Fatal("error unsubscribing", err.Error())
+	}
+	if err := client.UnsubscribePattern(redeems); err != ErrSubscriptionNotFound {
+		t.Fatal("expected ErrSubscriptionNotFound, got", err)
+	}
+
+	// A broader subscription made later doesn't affect the existing one
+	all, err := client.SubscribePrefix("twitch/")
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+	if err := client.SetKey("twitch/ev/brock/cheer", "1"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	expect(cheers, "twitch/ev/brock/cheer")
+	expect(all, "twitch/ev/brock/cheer")
+
+	// New patterns reuse a subscription that covers them
+	follows, err := client.SubscribePattern("twitch/ev/*/follow")
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+	if client.patternsubs.prefixes[follows] != "twitch/" {
+		t.Fatal("pattern did not reuse the covering subscription", client.patternsubs.prefixes[follows])
+	}
+	if err := client.UnsubscribePattern(cheers); err != nil {
+		t.Fatal("error unsubscribing", err.Error())
+	}
+	if err := client.SetKey("twitch/ev/brock/follow", "1"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	expect(follows, "twitch/ev/brock/follow")
+}
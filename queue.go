@@ -0,0 +1,428 @@
+package kvclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	kv "github.com/strimertul/kilovolt/v11"
+)
+
+var ErrQueueFull = errors.New("offline write queue is full")
+
+// QueuedWrite is a write waiting to be replayed. Writes with a single key are
+// replayed with CmdWriteKey, the others with CmdWriteBulk.
+type QueuedWrite struct {
+	ID   uint64            `json:"id"`
+	Data map[string]string `json:"data"`
+}
+
+// WriteQueue holds writes made while offline, see ClientOptions.OfflineQueue.
+// Implementations must be safe for concurrent use.
+type WriteQueue interface {
+	// Push adds a write at the end of the queue
+	Push(data map[string]string) error
+
+	// Pending returns all writes in the queue, oldest first
+	Pending() ([]QueuedWrite, error)
+
+	// Ack removes a write from the queue once it has been replayed (or discarded)
+	Ack(id uint64) error
+
+	// Len returns the number of writes in the queue
+	Len() int
+}
+
+// QueueOptions configures the built-in write queues
+type QueueOptions struct {
+	// MaxWrites is the maximum number of writes in the queue, after which new
+	// writes fail with ErrQueueFull. Zero means unlimited.
+	MaxWrites int
+
+	// Collapse removes keys from older writes when a newer write to the same key is
+	// queued, so that only the latest value of every key is replayed
+	Collapse bool
+}
+
+// memoryQueue is a WriteQueue kept in memory
+type memoryQueue struct {
+	options QueueOptions
+	mu      sync.Mutex
+	writes  []QueuedWrite
+	nextID  uint64
+}
+
+// NewMemoryQueue creates a write queue that only lives in memory
+func NewMemoryQueue(options QueueOptions) WriteQueue {
+	return &memoryQueue{options: options, nextID: 1}
+}
+
+func (q *memoryQueue) Push(data map[string]string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.push(q.nextID, data)
+	return err
+}
+
+// push adds a write with the given ID, the caller must hold the lock.
+// The queue is left untouched if the write is rejected.
+func (q *memoryQueue) push(id uint64, data map[string]string) (QueuedWrite, error) {
+	writes := q.writes
+	if q.options.Collapse {
+		writes = q.collapsed(data)
+	}
+	if q.options.MaxWrites > 0 && len(writes) >= q.options.MaxWrites {
+		return QueuedWrite{}, ErrQueueFull
+	}
+
+	write := QueuedWrite{ID: id, Data: make(map[string]string, len(data))}
+	for k, v := range data {
+		write.Data[k] = v
+	}
+	q.writes = append(writes, write)
+	if id >= q.nextID {
+		q.nextID = id + 1
+	}
+	return write, nil
+}
+
+// collapsed returns the queued writes without the given keys, dropping writes left
+// empty. The queue itself is not changed.
+func (q *memoryQueue) collapsed(data map[string]string) []QueuedWrite {
+	writes := make([]QueuedWrite, 0, len(q.writes)+1)
+	for _, write := range q.writes {
+		overlaps := false
+		for key := range data {
+			if _, ok := write.Data[key]; ok {
+				overlaps = true
+				break
+			}
+		}
+		if overlaps {
+			// Copy, Pending may have handed out the current map
+			remaining := make(map[string]string, len(write.Data))
+			for k, v := range write.Data {
+				if _, ok := data[k]; !ok {
+					remaining[k] = v
+				}
+			}
+			if len(remaining) < 1 {
+				continue
+			}
+			write.Data = remaining
+		}
+		writes = append(writes, write)
+	}
+	return writes
+}
+
+func (q *memoryQueue) Pending() ([]QueuedWrite, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]QueuedWrite(nil), q.writes...), nil
+}
+
+func (q *memoryQueue) Ack(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.ack(id)
+	return nil
+}
+
+func (q *memoryQueue) ack(id uint64) {
+	for i, write := range q.writes {
+		if write.ID == id {
+			q.writes = append(q.writes[:i:i], q.writes[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *memoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.writes)
+}
+
+// fileQueue is a memoryQueue backed by an append-only journal file
+type fileQueue struct {
+	memoryQueue
+	file *os.File
+}
+
+type journalEntry struct {
+	Push *QueuedWrite `json:"push,omitempty"`
+	Ack  uint64       `json:"ack,omitempty"`
+}
+
+// NewFileQueue creates a write queue persisted to a journal file at path, so
+// that queued writes survive restarts. Any writes already in the journal are loaded.
+// The returned queue also implements io.Closer, to close the journal file.
+func NewFileQueue(path string, options QueueOptions) (WriteQueue, error) {
+	q := &fileQueue{memoryQueue: memoryQueue{options: options, nextID: 1}}
+
+	if err := q.load(path); err != nil {
+		return nil, err
+	}
+
+	// Rewrite the journal with only the pending writes
+	if err := q.compact(path); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (q *fileQueue) load(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := jsoniter.ConfigFastest.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Likely a torn write from a crash, nothing after it can be trusted
+			break
+		}
+		switch {
+		case entry.Push != nil:
+			// Ignore the size limit, these writes were accepted already
+			max := q.options.MaxWrites
+			q.options.MaxWrites = 0
+			_, _ = q.push(entry.Push.ID, entry.Push.Data)
+			q.options.MaxWrites = max
+		case entry.Ack != 0:
+			q.ack(entry.Ack)
+		}
+	}
+	return scanner.Err()
+}
+
+func (q *fileQueue) compact(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	for i := range q.writes {
+		if err := writeJournalEntry(tmp, journalEntry{Push: &q.writes[i]}); err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	q.file = tmp
+	return nil
+}
+
+func (q *fileQueue) Push(data map[string]string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// push never changes the current slice or its maps, so this is enough to undo it
+	previous, nextID := q.writes, q.nextID
+	write, err := q.push(q.nextID, data)
+	if err != nil {
+		return err
+	}
+	if err := writeJournalEntry(q.file, journalEntry{Push: &write}); err != nil {
+		// Keep memory in line with the journal, the older writes may have been collapsed
+		q.writes, q.nextID = previous, nextID
+		return err
+	}
+	return q.file.Sync()
+}
+
+func (q *fileQueue) Ack(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.ack(id)
+
+	// Nothing left to keep, start over with an empty journal
+	if len(q.writes) < 1 {
+		if err := q.file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := q.file.Seek(0, 0); err != nil {
+			return err
+		}
+		return q.file.Sync()
+	}
+
+	if err := writeJournalEntry(q.file, journalEntry{Ack: id}); err != nil {
+		return err
+	}
+	return q.file.Sync()
+}
+
+// Close closes the journal file
+func (q *fileQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.file.Close()
+}
+
+func writeJournalEntry(file *os.File, entry journalEntry) error {
+	line, err := jsoniter.ConfigFastest.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+type replayKey struct{}
+
+// offlineInterceptor queues writes while the connection is down (or while older
+// writes are still waiting to be replayed, to keep them in order)
+func (s *Client) offlineInterceptor(ctx context.Context, request kv.Request, next Invoker) (kv.Response, error) {
+	if request.CmdName != kv.CmdWriteKey && request.CmdName != kv.CmdWriteBulk || ctx.Value(replayKey{}) != nil {
+		return next(ctx, request)
+	}
+
+	if s.Connected() && s.queue.Len() < 1 {
+		res, err := next(ctx, request)
+		var serverError *ServerError
+		if err == nil || errors.As(err, &serverError) || ctx.Err() != nil {
+			return res, err
+		}
+		s.Logger.Warn("write failed, queueing it", "error", err)
+	}
+
+	if err := s.queue.Push(writeData(request)); err != nil {
+		return kv.Response{}, err
+	}
+	if s.Connected() {
+		go s.flushInBackground()
+	}
+
+	return kv.Response{CmdType: "response", Ok: true, RequestID: request.RequestID}, nil
+}
+
+// writeData extracts the written keys and values from a write request
+func writeData(request kv.Request) map[string]string {
+	data := make(map[string]string)
+	if request.CmdName == kv.CmdWriteKey {
+		key, _ := request.Data["key"].(string)
+		data[key], _ = request.Data["data"].(string)
+		return data
+	}
+	for k, v := range request.Data {
+		data[k], _ = v.(string)
+	}
+	return data
+}
+
+// FlushQueue replays all queued writes in order, stopping at the first failure
+func (s *Client) FlushQueue(ctx context.Context) error {
+	if s.queue == nil {
+		return nil
+	}
+
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	ctx = context.WithValue(ctx, replayKey{}, true)
+	for {
+		writes, err := s.queue.Pending()
+		if err != nil {
+			return err
+		}
+		if len(writes) < 1 {
+			return nil
+		}
+
+		for _, write := range writes {
+			if _, err := s.makeRequestContext(ctx, write.request()); err != nil {
+				return err
+			}
+			if err := s.queue.Ack(write.ID); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Client) flushInBackground() {
+	// Someone is already flushing
+	if !s.flushMu.TryLock() {
+		return
+	}
+	s.flushMu.Unlock()
+
+	if err := s.FlushQueue(context.Background()); err != nil {
+		s.Logger.Error("could not replay queued writes", "error", err)
+		s.reportError(err)
+	}
+}
+
+// DrainQueue removes all queued writes without replaying them and returns them
+func (s *Client) DrainQueue() ([]QueuedWrite, error) {
+	if s.queue == nil {
+		return nil, nil
+	}
+
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	writes, err := s.queue.Pending()
+	if err != nil {
+		return nil, err
+	}
+	for _, write := range writes {
+		if err := s.queue.Ack(write.ID); err != nil {
+			return nil, err
+		}
+	}
+	return writes, nil
+}
+
+func (w QueuedWrite) request() kv.Request {
+	if len(w.Data) == 1 {
+		for key, value := range w.Data {
+			return kv.Request{
+				CmdName: kv.CmdWriteKey,
+				Data: map[string]interface{}{
+					"key":  key,
+					"data": value,
+				},
+			}
+		}
+	}
+
+	data := make(map[string]interface{}, len(w.Data))
+	for k, v := range w.Data {
+		data[k] = v
+	}
+	return kv.Request{
+		CmdName: kv.CmdWriteBulk,
+		Data:    data,
+	}
+}
@@ -0,0 +1,259 @@
+package kvclient
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kv "github.com/strimertul/kilovolt/v11"
+	"go.uber.org/zap"
+)
+
+func TestMemoryQueueCollapse(t *testing.T) {
+	queue := NewMemoryQueue(QueueOptions{Collapse: true, MaxWrites: 2})
+
+	for _, data := range []map[string]string{
+		{"a": "1"},
+		{"b": "2"},
+		{"a": "3", "c": "4"},
+	} {
+		if err := queue.Push(data); err != nil {
+			t.Fatal("error pushing write", err.Error())
+		}
+	}
+
+	writes, _ := queue.Pending()
+	if len(writes) != 2 {
+		t.Fatal("wrong number of writes", writes)
+	}
+	if writes[0].Data["b"] != "2" || len(writes[0].Data) != 1 {
+		t.Fatal("wrong first write", writes[0])
+	}
+	if writes[1].Data["a"] != "3" || writes[1].Data["c"] != "4" {
+		t.Fatal("wrong second write", writes[1])
+	}
+
+	if err := queue.Push(map[string]string{"d": "5"}); err != ErrQueueFull {
+		t.Fatal("expected ErrQueueFull, got", err)
+	}
+	// Collapsing makes room
+	if err := queue.Push(map[string]string{"b": "6"}); err != nil {
+		t.Fatal("error pushing collapsing write", err.Error())
+	}
+
+	if err := queue.Ack(writes[1].ID); err != nil {
+		t.Fatal("error acking write", err.Error())
+	}
+	if queue.Len() != 1 {
+		t.Fatal("wrong queue length after ack", queue.Len())
+	}
+
+	// A rejected write must not collapse the older ones
+	if err := queue.Push(map[string]string{"x": "7", "y": "8"}); err != nil {
+		t.Fatal("error pushing write", err.Error())
+	}
+	if err := queue.Push(map[string]string{"x": "9"}); err != ErrQueueFull {
+		t.Fatal("expected ErrQueueFull, got", err)
+	}
+	writes, _ = queue.Pending()
+	if len(writes) != 2 || writes[1].Data["x"] != "7" || writes[1].Data["y"] != "8" {
+		t.Fatal("rejected write changed the queue", writes)
+	}
+}
+
+func TestFileQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.journal")
+
+	queue, err := NewFileQueue(path, QueueOptions{})
+	if err != nil {
+		t.Fatal("error creating queue", err.Error())
+	}
+	for _, data := range []map[string]string{{"a": "1"}, {"b": "2"}, {"c": "3"}} {
+		if err := queue.Push(data); err != nil {
+			t.Fatal("error pushing write", err.Error())
+		}
+	}
+	writes, _ := queue.Pending()
+	if err := queue.Ack(writes[0].ID); err != nil {
+		t.Fatal("error acking write", err.Error())
+	}
+	_ = queue.(interface{ Close() error }).Close()
+
+	// Simulate a crash in the middle of a write
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal("error opening journal", err.Error())
+	}
+	_, _ = file.WriteString(`{"push":{"id":4,"da`)
+	_ = file.Close()
+
+	queue, err = NewFileQueue(path, QueueOptions{})
+	if err != nil {
+		t.Fatal("error reopening queue", err.Error())
+	}
+	defer queue.(interface{ Close() error }).Close()
+
+	writes, _ = queue.Pending()
+	if len(writes) != 2 || writes[0].Data["b"] != "2" || writes[1].Data["c"] != "3" {
+		t.Fatal("wrong writes after reload", writes)
+	}
+
+	// New writes must not reuse IDs
+	if err := queue.Push(map[string]string{"d": "4"}); err != nil {
+		t.Fatal("error pushing write", err.Error())
+	}
+	writes, _ = queue.Pending()
+	if writes[2].ID <= writes[1].ID {
+		t.Fatal("reused write ID", writes)
+	}
+}
+
+func TestOfflineQueue(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server := createFlakyKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger:            ZapLogger(log),
+		ReconnectInterval: 20 * time.Millisecond,
+		OfflineQueue:      NewMemoryQueue(QueueOptions{Collapse: true}),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	server.setDown(true)
+	waitFor(t, "client to notice the disconnection", func() bool { return !client.Connected() })
+
+	for _, value := range []string{"1", "2", "3"} {
+		if err := client.SetKey("offline", value); err != nil {
+			t.Fatal("error queueing write", err.Error())
+		}
+	}
+	if err := client.SetJSONs(map[string]interface{}{"bulk1": 1, "bulk2": 2}); err != nil {
+		t.Fatal("error queueing write", err.Error())
+	}
+	if pending := client.queue.Len(); pending != 2 {
+		t.Fatalf("wrong number of queued writes, expected=2 got=%d", pending)
+	}
+
+	server.setDown(false)
+	waitFor(t, "queued writes to be replayed", func() bool { return client.Connected() && client.queue.Len() == 0 })
+
+	vals, err := client.GetKeys([]string{"offline", "bulk1", "bulk2"})
+	if err != nil {
+		t.Fatal("error getting keys", err.Error())
+	}
+	if vals["offline"] != "3" || vals["bulk1"] != "1" || vals["bulk2"] != "2" {
+		t.Fatal("queued writes not replayed correctly", vals)
+	}
+}
+
+func TestDrainQueue(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server := createFlakyKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger:       ZapLogger(log),
+		OfflineQueue: NewMemoryQueue(QueueOptions{}),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	server.setDown(true)
+	waitFor(t, "client to notice the disconnection", func() bool { return !client.Connected() })
+
+	if err := client.SetKey("offline", "value"); err != nil {
+		t.Fatal("error queueing write", err.Error())
+	}
+	writes, err := client.DrainQueue()
+	if err != nil {
+		t.Fatal("error draining queue", err.Error())
+	}
+	if len(writes) != 1 || writes[0].Data["offline"] != "value" {
+		t.Fatal("wrong writes drained", writes)
+	}
+	if client.queue.Len() != 0 {
+		t.Fatal("queue not empty after draining")
+	}
+}
+
+type flakyServer struct {
+	*httptest.Server
+	down  int32
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// setDown kills all current connections and refuses new ones until set back up
+func (f *flakyServer) setDown(down bool) {
+	if !down {
+		atomic.StoreInt32(&f.down, 0)
+		return
+	}
+	atomic.StoreInt32(&f.down, 1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		_ = conn.Close()
+	}
+	f.conns = nil
+}
+
+type trackingWriter struct {
+	http.ResponseWriter
+	server *flakyServer
+}
+
+func (w trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		w.server.mu.Lock()
+		// The handshake response is sent before hijacking, so the client may already
+		// be using a connection that was accepted before the server went down
+		if atomic.LoadInt32(&w.server.down) == 1 {
+			_ = conn.Close()
+		} else {
+			w.server.conns = append(w.server.conns, conn)
+		}
+		w.server.mu.Unlock()
+	}
+	return conn, rw, err
+}
+
+func createFlakyKV(t *testing.T, log *zap.Logger) *flakyServer {
+	hub, err := kv.NewHub(kv.MakeBackend(), kv.HubOptions{}, log)
+	if err != nil {
+		t.Fatal("hub initialization failed", err.Error())
+	}
+	go hub.Run()
+
+	server := &flakyServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&server.down) == 1 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		kv.ServeWs(hub, trackingWriter{w, server}, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
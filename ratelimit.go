@@ -0,0 +1,213 @@
+package kvclient
+
+import (
+	"sync"
+	"time"
+)
+
+// SubscribeOption changes how pushes are delivered to a subscription
+type SubscribeOption func(*subscribeOptions)
+
+type rateMode int
+
+const (
+	rateNone rateMode = iota
+	rateDebounce
+	rateThrottle
+	rateSample
+)
+
+type subscribeOptions struct {
+	mode     rateMode
+	interval time.Duration
+	clock    Clock
+}
+
+// Debounce delivers a key's latest value once it stops changing for the quiet
+// period, so a burst of changes results in a single push
+func Debounce(quiet time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.mode, o.interval = rateDebounce, quiet
+	}
+}
+
+// Throttle delivers at most one push per key every interval: the first change is
+// delivered right away, later ones are held back and only the latest value is
+// delivered at the end of the interval
+func Throttle(interval time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.mode, o.interval = rateThrottle, interval
+	}
+}
+
+// Sample delivers the latest value of each key every interval, as long as it has
+// changed since the previous sample
+func Sample(interval time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.mode, o.interval = rateSample, interval
+	}
+}
+
+// WithClock sets the clock used by Debounce, Throttle and Sample, defaults to SystemClock
+func WithClock(clock Clock) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.clock = clock
+	}
+}
+
+// newSubscriber creates a subscriber for chn, applying filter (if any) and then the options
+func newSubscriber(chn chan KeyValuePair, filter func(KeyValuePair) (KeyValuePair, bool), options []SubscribeOption) *subscriber {
+	sub := &subscriber{ch: chn, filter: filter}
+
+	opts := subscribeOptions{clock: SystemClock}
+	for _, option := range options {
+		option(&opts)
+	}
+	if opts.mode == rateNone || opts.interval <= 0 {
+		return sub
+	}
+
+	limiter := &rateLimiter{
+		options: opts,
+		ch:      chn,
+		done:    make(chan struct{}),
+		keys:    make(map[string]*rateKey),
+	}
+	sub.filter = func(push KeyValuePair) (KeyValuePair, bool) {
+		if filter != nil {
+			var ok bool
+			if push, ok = filter(push); !ok {
+				return push, false
+			}
+		}
+		// Runs in the read loop, delivery is left to the limiter's timers
+		limiter.record(push)
+		return push, false
+	}
+	sub.close = limiter.stop
+	return sub
+}
+
+// rateLimiter holds back pushes for a subscription and delivers them from timers
+type rateLimiter struct {
+	options subscribeOptions
+	ch      chan KeyValuePair
+	done    chan struct{}
+
+	mu      sync.Mutex
+	keys    map[string]*rateKey
+	stopped bool
+}
+
+type rateKey struct {
+	latest  KeyValuePair
+	pending bool  // latest hasn't been delivered yet
+	timer   Timer // Scheduled delivery, nil if there is none
+	gen     uint64
+}
+
+func (l *rateLimiter) record(push KeyValuePair) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return
+	}
+
+	k, ok := l.keys[push.Key]
+	if !ok {
+		k = &rateKey{}
+		l.keys[push.Key] = k
+	}
+	k.latest, k.pending = push, true
+
+	switch l.options.mode {
+	case rateDebounce:
+		// Start the quiet period over
+		if k.timer != nil {
+			k.timer.Stop()
+		}
+		l.schedule(push.Key, k)
+	case rateThrottle:
+		if k.timer != nil {
+			return
+		}
+		// Deliver right away if it can be done without blocking the read loop,
+		// otherwise it will be delivered at the end of the interval
+		select {
+		case l.ch <- push:
+			k.pending = false
+		default:
+		}
+		l.schedule(push.Key, k)
+	case rateSample:
+		if k.timer == nil {
+			l.schedule(push.Key, k)
+		}
+	}
+}
+
+// schedule sets up the next delivery for a key, the caller must hold the lock
+func (l *rateLimiter) schedule(key string, k *rateKey) {
+	k.gen++
+	gen := k.gen
+	k.timer = l.options.clock.AfterFunc(l.options.interval, func() {
+		l.fire(key, gen)
+	})
+}
+
+func (l *rateLimiter) fire(key string, gen uint64) {
+	l.mu.Lock()
+	k := l.keys[key]
+	// Stopped, or replaced by a newer timer that Stop came too late for
+	if l.stopped || k == nil || k.gen != gen {
+		l.mu.Unlock()
+		return
+	}
+	if !k.pending {
+		// Nothing changed during the interval, go idle
+		delete(l.keys, key)
+		l.mu.Unlock()
+		return
+	}
+	push := k.latest
+	k.pending = false
+	l.mu.Unlock()
+
+	select {
+	case l.ch <- push:
+	case <-l.done:
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped || k.gen != gen {
+		return
+	}
+	switch l.options.mode {
+	case rateDebounce:
+		if !k.pending {
+			delete(l.keys, key)
+		}
+	case rateThrottle, rateSample:
+		// Keep the window open, changes made in the meantime are delivered at its end
+		l.schedule(key, k)
+	}
+}
+
+// stop cancels all pending deliveries
+func (l *rateLimiter) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return
+	}
+	l.stopped = true
+	close(l.done)
+	for _, k := range l.keys {
+		if k.timer != nil {
+			k.timer.Stop()
+		}
+	}
+	l.keys = nil
+}
@@ -0,0 +1,150 @@
+package kvclient
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRateLimitedSubscriptions(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	// subscribe returns a rate limited subscription and a set function that only
+	// returns once the push has gone through the read loop
+	subscribe := func(prefix string, options ...SubscribeOption) (chan KeyValuePair, func(key, value string)) {
+		t.Helper()
+		chn, err := client.SubscribePrefix(prefix, options...)
+		if err != nil {
+			t.Fatal("error subscribing", err.Error())
+		}
+		// Subscribed later, so it receives pushes after the limited subscription
+		probe, err := client.SubscribePrefix(prefix)
+		if err != nil {
+			t.Fatal("error subscribing", err.Error())
+		}
+		return chn, func(key, value string) {
+			t.Helper()
+			if err := client.SetKey(prefix+key, value); err != nil {
+				t.Fatal("error setting key", err.Error())
+			}
+			select {
+			case <-probe:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for push")
+			}
+		}
+	}
+	expect := func(chn chan KeyValuePair, values ...string) {
+		t.Helper()
+		var got []string
+		for len(chn) > 0 {
+			got = append(got, (<-chn).Value)
+		}
+		if len(got) != len(values) {
+			t.Fatalf("wrong pushes delivered, expected=%v got=%v", values, got)
+		}
+		for i := range values {
+			if got[i] != values[i] {
+				t.Fatalf("wrong pushes delivered, expected=%v got=%v", values, got)
+			}
+		}
+	}
+
+	t.Run("Debounce", func(t *testing.T) {
+		clock := newFakeClock()
+		chn, set := subscribe("debounce/", Debounce(100*time.Millisecond), WithClock(clock))
+
+		set("level", "1")
+		set("level", "2")
+		set("level", "3")
+		expect(chn)
+		clock.Advance(50 * time.Millisecond)
+		set("level", "4")
+		clock.Advance(99 * time.Millisecond)
+		expect(chn)
+		clock.Advance(time.Millisecond)
+		expect(chn, "4")
+		clock.Advance(time.Second)
+		expect(chn)
+	})
+
+	t.Run("Throttle", func(t *testing.T) {
+		clock := newFakeClock()
+		chn, set := subscribe("throttle/", Throttle(100*time.Millisecond), WithClock(clock))
+
+		set("timer", "1")
+		expect(chn, "1")
+		set("timer", "2")
+		set("timer", "3")
+		clock.Advance(99 * time.Millisecond)
+		expect(chn)
+		clock.Advance(time.Millisecond)
+		expect(chn, "3")
+		set("timer", "4")
+		clock.Advance(100 * time.Millisecond)
+		expect(chn, "4")
+		// Nothing changed in the last interval, the next change goes through right away
+		clock.Advance(100 * time.Millisecond)
+		expect(chn)
+		set("timer", "5")
+		expect(chn, "5")
+
+		// Keys are throttled separately
+		set("other", "a")
+		expect(chn, "a")
+	})
+
+	t.Run("Sample", func(t *testing.T) {
+		clock := newFakeClock()
+		chn, set := subscribe("sample/", Sample(100*time.Millisecond), WithClock(clock))
+
+		set("audio", "1")
+		set("audio", "2")
+		expect(chn)
+		clock.Advance(100 * time.Millisecond)
+		expect(chn, "2")
+		set("audio", "3")
+		clock.Advance(100 * time.Millisecond)
+		expect(chn, "3")
+		clock.Advance(300 * time.Millisecond)
+		expect(chn)
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		clock := newFakeClock()
+		chn, set := subscribe("unsubscribe/", Debounce(100*time.Millisecond), WithClock(clock))
+
+		set("level", "1")
+		if err := client.UnsubscribePrefix("unsubscribe/", chn); err != nil {
+			t.Fatal("error unsubscribing", err.Error())
+		}
+		clock.Advance(time.Second)
+		expect(chn)
+	})
+
+	t.Run("Namespace", func(t *testing.T) {
+		clock := newFakeClock()
+		chn, err := client.Namespace("ns/").SubscribeKey("level", Debounce(100*time.Millisecond), WithClock(clock))
+		if err != nil {
+			t.Fatal("error subscribing", err.Error())
+		}
+		_, set := subscribe("ns/")
+		set("level", "1")
+		clock.Advance(100 * time.Millisecond)
+		select {
+		case push := <-chn:
+			if push.Key != "level" || push.Value != "1" {
+				t.Fatal("unexpected push", push)
+			}
+		default:
+			t.Fatal("debounced push was not delivered")
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package kvclient
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync/atomic"
+)
+
+// RequestIDGenerator returns a new request ID every time it's called.
+// It must be safe for concurrent use, as requests can be made from multiple goroutines.
+// IDs only need to be unique among in-flight requests of a single client.
+type RequestIDGenerator func() string
+
+// CounterIDGenerator returns a generator of hexadecimal IDs from a monotonic counter starting at 1.
+// This is the default generator, and it's deterministic, which makes it useful in tests.
+func CounterIDGenerator() RequestIDGenerator {
+	var counter uint64
+	return func() string {
+		return fmt.Sprintf("%x", atomic.AddUint64(&counter, 1))
+	}
+}
+
+// UUIDGenerator returns a generator of random (version 4) UUIDs
+func UUIDGenerator() RequestIDGenerator {
+	return func() string {
+		var uuid [16]byte
+		if _, err := rand.Read(uuid[:]); err != nil {
+			panic(fmt.Errorf("could not read random bytes for UUID: %w", err))
+		}
+		uuid[6] = (uuid[6] & 0x0f) | 0x40 // Version 4
+		uuid[8] = (uuid[8] & 0x3f) | 0x80 // RFC 4122 variant
+		return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+	}
+}
+
+// PrefixedIDGenerator prepends prefix to every ID returned by next (or by a new
+// counter generator if next is nil). Use it to embed trace/correlation info so
+// that requests can be matched in server-side logs.
+func PrefixedIDGenerator(prefix string, next RequestIDGenerator) RequestIDGenerator {
+	if next == nil {
+		next = CounterIDGenerator()
+	}
+	return func() string {
+		return prefix + next()
+	}
+}
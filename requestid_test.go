@@ -0,0 +1,84 @@
+package kvclient
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+
+	kv "github.com/strimertul/kilovolt/v11"
+)
+
+func TestCounterIDGenerator(t *testing.T) {
+	gen := CounterIDGenerator()
+	for _, expected := range []string{"1", "2", "3"} {
+		if id := gen(); id != expected {
+			t.Fatalf("unexpected ID, expected=%s got=%s", expected, id)
+		}
+	}
+}
+
+func TestUUIDGenerator(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	gen := UUIDGenerator()
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := gen()
+		if !format.MatchString(id) {
+			t.Fatal("generated ID is not a v4 UUID", id)
+		}
+		if seen[id] {
+			t.Fatal("generated duplicate UUID", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestPrefixedIDGenerator(t *testing.T) {
+	gen := PrefixedIDGenerator("trace-abc/", nil)
+	if id := gen(); id != "trace-abc/1" {
+		t.Fatalf("unexpected ID, expected=%s got=%s", "trace-abc/1", id)
+	}
+}
+
+func TestRequestIDOption(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+
+	var mu sync.Mutex
+	var received []string
+	server := createScriptedServer(t, func(_ int, req kv.Request) string {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, req.RequestID)
+		return fmt.Sprintf("{\"type\":\"response\",\"ok\":true,\"request_id\":\"%s\",\"data\":\"\"}", req.RequestID)
+	})
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger:             ZapLogger(log),
+		RequestIDGenerator: PrefixedIDGenerator("bot-", nil),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetKey("test"); err != nil {
+			t.Fatal("error getting key", err.Error())
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"bot-1", "bot-2", "bot-3"}
+	if len(received) != len(expected) {
+		t.Fatal("wrong number of requests received", received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("unexpected request ID, expected=%s got=%s", expected[i], received[i])
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// DefaultCallTimeout is how long Call waits for a reply if ctx has no deadline
+const DefaultCallTimeout = 30 * time.Second
+
+var ErrCallTimeout = errors.New("timed out waiting for reply")
+
+// RPCRequest is what Call writes to the request key
+type RPCRequest struct {
+	CorrelationID string              `json:"correlation_id"`
+	ReplyTo       string              `json:"reply_to"`
+	Payload       jsoniter.RawMessage `json:"payload,omitempty"`
+}
+
+// RPCReply is what responders write to the reply key
+type RPCReply struct {
+	CorrelationID string              `json:"correlation_id"`
+	Payload       jsoniter.RawMessage `json:"payload,omitempty"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// RPCError is an error returned by the responder
+type RPCError struct {
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return "remote error: " + e.Message
+}
+
+// Call writes payload (encoded as JSON) to requestKey and waits for the matching reply
+// on replyKey, returning its JSON payload. Replies are matched to the request by a
+// correlation ID, so many callers can share the same keys.
+func (s *Client) Call(ctx context.Context, requestKey, replyKey string, payload interface{}) (jsoniter.RawMessage, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultCallTimeout)
+		defer cancel()
+	}
+
+	data, err := jsoniter.ConfigFastest.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	request := RPCRequest{
+		CorrelationID: UUIDGenerator()(),
+		ReplyTo:       replyKey,
+		Payload:       data,
+	}
+
+	// Subscribe before writing the request, or a quick reply could be missed.
+	// Nothing reads the channel while the request is being written, so replies to
+	// other callers sharing the key are dropped by the read loop, and the slot is
+	// emptied before delivering ours in case the responder sends it twice.
+	chn := make(chan KeyValuePair, 1)
+	if err := s.subscribeKey(replyKey, &subscriber{ch: chn, filter: func(pair KeyValuePair) (KeyValuePair, bool) {
+		if _, ok := decodeReply(pair.Value, request.CorrelationID); !ok {
+			return pair, false
+		}
+		select {
+		case <-chn:
+		default:
+		}
+		return pair, true
+	}}); err != nil {
+		return nil, err
+	}
+	defer s.UnsubscribeKey(replyKey, chn)
+
+	if err := s.SetJSON(requestKey, request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %w", ErrCallTimeout, ctx.Err())
+		}
+		return nil, ctx.Err()
+	case pair := <-chn:
+		reply, _ := decodeReply(pair.Value, request.CorrelationID)
+		if reply.Error != "" {
+			return nil, &RPCError{Message: reply.Error}
+		}
+		return reply.Payload, nil
+	}
+}
+
+// decodeReply decodes value as the reply to the request with the given correlation ID,
+// returning false if it's something else
+func decodeReply(value string, correlationID string) (RPCReply, bool) {
+	// Requests and replies may share the same key, requests have a reply_to field
+	var reply struct {
+		RPCReply
+		ReplyTo string `json:"reply_to"`
+	}
+	if err := jsoniter.ConfigFastest.UnmarshalFromString(value, &reply); err != nil || reply.ReplyTo != "" || reply.CorrelationID != correlationID {
+		return RPCReply{}, false
+	}
+	return reply.RPCReply, true
+}
+
+// RPCHandler handles a request, the returned value is encoded as JSON and sent as reply
+type RPCHandler func(ctx context.Context, payload jsoniter.RawMessage) (interface{}, error)
+
+// Responder answers requests written to a key, see Serve
+type Responder struct {
+	client  *Client
+	key     string
+	handler RPCHandler
+	chn     chan KeyValuePair
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// Serve calls handler for every request written to requestKey by Call, writing its
+// result to the reply key chosen by the caller. Requests are handled concurrently.
+func (s *Client) Serve(requestKey string, handler RPCHandler) (*Responder, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	responder := &Responder{
+		client:  s,
+		key:     requestKey,
+		handler: handler,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	var err error
+	responder.chn, err = s.SubscribeKey(requestKey)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	responder.wg.Add(1)
+	go responder.receive()
+	return responder, nil
+}
+
+// Close stops answering requests, cancelling the context of running handlers and waiting for them
+func (r *Responder) Close() error {
+	var err error
+	r.once.Do(func() {
+		err = r.client.UnsubscribeKey(r.key, r.chn)
+		r.cancel()
+		r.wg.Wait()
+	})
+	return err
+}
+
+func (r *Responder) receive() {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case pair := <-r.chn:
+			if pair.Value == "" {
+				continue
+			}
+			var request RPCRequest
+			if err := jsoniter.ConfigFastest.UnmarshalFromString(pair.Value, &request); err != nil || request.CorrelationID == "" {
+				r.client.Logger.Warn("ignoring invalid request", "key", r.key)
+				continue
+			}
+			if request.ReplyTo == "" {
+				// A reply written to the same key
+				continue
+			}
+			r.wg.Add(1)
+			go r.handle(request)
+		}
+	}
+}
+
+func (r *Responder) handle(request RPCRequest) {
+	defer r.wg.Done()
+
+	reply := RPCReply{CorrelationID: request.CorrelationID}
+	result, err := r.handler(r.ctx, request.Payload)
+	if err == nil {
+		reply.Payload, err = jsoniter.ConfigFastest.Marshal(result)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+
+	if err := r.client.SetJSON(request.ReplyTo, reply); err != nil {
+		r.client.Logger.Warn("could not send reply", "key", request.ReplyTo, "error", err)
+	}
+}
@@ -0,0 +1,255 @@
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	kv "github.com/strimertul/kilovolt/v11"
+	"go.uber.org/zap"
+)
+
+func TestRPC(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	caller, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	callee, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	responder, err := callee.Serve("rpc/upper", func(ctx context.Context, payload jsoniter.RawMessage) (interface{}, error) {
+		var text string
+		if err := jsoniter.ConfigFastest.Unmarshal(payload, &text); err != nil {
+			return nil, err
+		}
+		if text == "" {
+			return nil, errors.New("nothing to do")
+		}
+		return strings.ToUpper(text), nil
+	})
+	if err != nil {
+		t.Fatal("error starting responder", err.Error())
+	}
+	defer responder.Close()
+
+	call := func(key, reply string, payload interface{}) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		data, err := caller.Call(ctx, key, reply, payload)
+		if err != nil {
+			return "", err
+		}
+		var result string
+		err = jsoniter.ConfigFastest.Unmarshal(data, &result)
+		return result, err
+	}
+
+	t.Run("Call", func(t *testing.T) {
+		result, err := call("rpc/upper", "rpc/upper/reply", "hello")
+		if err != nil {
+			t.Fatal("error calling", err.Error())
+		}
+		if result != "HELLO" {
+			t.Fatal("unexpected result", result)
+		}
+	})
+
+	t.Run("Concurrent calls", func(t *testing.T) {
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				text := fmt.Sprintf("call %d", i)
+				result, err := call("rpc/upper", "rpc/upper/reply", text)
+				if err == nil && result != strings.ToUpper(text) {
+					err = fmt.Errorf("got reply for another call: expected=%s got=%s", strings.ToUpper(text), result)
+				}
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("Same key for requests and replies", func(t *testing.T) {
+		shared, err := callee.Serve("rpc/shared", func(ctx context.Context, payload jsoniter.RawMessage) (interface{}, error) {
+			return "pong", nil
+		})
+		if err != nil {
+			t.Fatal("error starting responder", err.Error())
+		}
+		defer shared.Close()
+
+		result, err := call("rpc/shared", "rpc/shared", "ping")
+		if err != nil {
+			t.Fatal("error calling", err.Error())
+		}
+		if result != "pong" {
+			t.Fatal("unexpected result", result)
+		}
+	})
+
+	t.Run("Remote error", func(t *testing.T) {
+		_, err := call("rpc/upper", "rpc/upper/reply", "")
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Message != "nothing to do" {
+			t.Fatal("expected RPCError, got", err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := caller.Call(ctx, "rpc/nobody", "rpc/nobody/reply", "hello?")
+		if !errors.Is(err, ErrCallTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expected ErrCallTimeout, got", err)
+		}
+		if caller.keysubs.Has("rpc/nobody/reply") {
+			subs, _ := caller.keysubs.Get("rpc/nobody/reply")
+			if len(subs.([]*subscriber)) > 0 {
+				t.Fatal("reply subscription was not removed")
+			}
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		if err := responder.Close(); err != nil {
+			t.Fatal("error closing responder", err.Error())
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if _, err := caller.Call(ctx, "rpc/upper", "rpc/upper/reply", "hello"); !errors.Is(err, ErrCallTimeout) {
+			t.Fatal("expected ErrCallTimeout after closing responder, got", err)
+		}
+	})
+}
+
+func TestRPCSharedReplyKey(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	caller, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	other, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	// Answers the request, then keeps replying to other callers on the same key
+	requests, err := other.SubscribeKey("rpc/shared/request")
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+	go func() {
+		var request RPCRequest
+		_ = jsoniter.ConfigFastest.UnmarshalFromString((<-requests).Value, &request)
+		_ = other.SetJSON(request.ReplyTo, RPCReply{CorrelationID: request.CorrelationID, Payload: jsoniter.RawMessage(`1`)})
+		for i := 0; i < 200; i++ {
+			_ = other.SetJSON(request.ReplyTo, RPCReply{CorrelationID: fmt.Sprintf("someone-else-%d", i)})
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := caller.Call(context.Background(), "rpc/shared/request", "rpc/shared/reply", nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal("error calling", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call deadlocked")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := caller.makeRequestContext(ctx, kv.Request{CmdName: kv.CmdReadKey, Data: map[string]interface{}{"key": "rpc/shared/reply"}}); err != nil {
+		t.Fatal("client stuck after call", err.Error())
+	}
+}
+
+func TestRPCManyCallers(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	// Slow down sending requests, so replies to other calls pile up meanwhile
+	slowRequests := func(ctx context.Context, request kv.Request, next Invoker) (kv.Response, error) {
+		if request.CmdName == kv.CmdWriteKey && request.Data["key"] == "rpc/echo" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return next(ctx, request)
+	}
+	caller, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log), Interceptors: []Interceptor{slowRequests}})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	callee, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	responder, err := callee.Serve("rpc/echo", func(ctx context.Context, payload jsoniter.RawMessage) (interface{}, error) {
+		return payload, nil
+	})
+	if err != nil {
+		t.Fatal("error starting responder", err.Error())
+	}
+	defer responder.Close()
+
+	// Every call sees the replies to all the others while waiting for its own
+	const callers = 100
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Staggered, so the replies to earlier calls arrive while later ones are sent
+			time.Sleep(time.Duration(i) * time.Millisecond)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			data, err := caller.Call(ctx, "rpc/echo", "rpc/echo/reply", i)
+			if err == nil && string(data) != fmt.Sprint(i) {
+				err = fmt.Errorf("got reply for another call: expected=%d got=%s", i, data)
+			}
+			errs <- err
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(15 * time.Second):
+		t.Fatal("calls deadlocked")
+	}
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
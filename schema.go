@@ -0,0 +1,420 @@
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	kv "github.com/strimertul/kilovolt/v11"
+)
+
+var ErrInvalidValue = errors.New("value failed validation")
+
+// Validator checks values before they are written to, or after they are read from, a key
+type Validator interface {
+	Validate(value string) error
+}
+
+// ValidatorFunc adapts a function to a Validator
+type ValidatorFunc func(value string) error
+
+func (f ValidatorFunc) Validate(value string) error {
+	return f(value)
+}
+
+// JSONValidator creates a Validator that decodes values as JSON into T and
+// checks them with validate (which can be nil to only check decoding)
+func JSONValidator[T any](validate func(T) error) Validator {
+	return ValidatorFunc(func(value string) error {
+		var decoded T
+		if err := jsoniter.ConfigFastest.UnmarshalFromString(value, &decoded); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+		if validate == nil {
+			return nil
+		}
+		return validate(decoded)
+	})
+}
+
+// ValidationError is returned for values that fail validation
+type ValidationError struct {
+	Key     string
+	Pattern string // Pattern of the validator that failed
+	Err     error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid value for %s (%s): %s", e.Key, e.Pattern, e.Err.Error())
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return []error{ErrInvalidValue, e.Err}
+}
+
+type registeredValidator struct {
+	pattern   *Pattern
+	validator Validator
+}
+
+// validators holds the validators registered on a client
+type validators struct {
+	mu   sync.RWMutex
+	list []registeredValidator
+}
+
+// RegisterValidator adds a validator for keys matching pattern (see ParsePattern,
+// e.g. "chat/*/history" or "settings/**"). SetKey, SetJSON and the other writes fail with a
+// *ValidationError without sending anything if a value fails any validator matching
+// its key. Values read with GetJSON and pushed to subscribers are checked too: GetJSON
+// returns the error, while pushes are still delivered and the error is reported to
+// ClientOptions.OnError. Empty values (deleted keys) are never validated.
+func (s *Client) RegisterValidator(pattern string, validator Validator) error {
+	p, err := ParsePattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	s.validators.mu.Lock()
+	defer s.validators.mu.Unlock()
+	s.validators.list = append(s.validators.list, registeredValidator{p, validator})
+	return nil
+}
+
+// UnregisterValidator removes all validators registered for pattern
+func (s *Client) UnregisterValidator(pattern string) {
+	s.validators.mu.Lock()
+	defer s.validators.mu.Unlock()
+
+	list := make([]registeredValidator, 0, len(s.validators.list))
+	for _, v := range s.validators.list {
+		if v.pattern.String() != pattern {
+			list = append(list, v)
+		}
+	}
+	s.validators.list = list
+}
+
+// Validate checks a value against every validator registered for key,
+// returning a *ValidationError for the first one it fails
+func (s *Client) Validate(key string, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	s.validators.mu.RLock()
+	defer s.validators.mu.RUnlock()
+	for _, v := range s.validators.list {
+		if !v.pattern.Match(key) {
+			continue
+		}
+		if err := v.validator.Validate(value); err != nil {
+			return &ValidationError{Key: key, Pattern: v.pattern.String(), Err: err}
+		}
+	}
+	return nil
+}
+
+// ValidatePrefix checks every key starting with prefix against the registered
+// validators, returning the errors of the keys that fail validation
+func (s *Client) ValidatePrefix(prefix string) (map[string]error, error) {
+	values, err := s.GetByPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	invalid := make(map[string]error)
+	for key, value := range values {
+		if err := s.Validate(key, value); err != nil {
+			invalid[key] = err
+		}
+	}
+	return invalid, nil
+}
+
+// validationInterceptor rejects writes with invalid values
+func (s *Client) validationInterceptor(ctx context.Context, request kv.Request, next Invoker) (kv.Response, error) {
+	// Queued writes were validated before being queued
+	if ctx.Value(replayKey{}) != nil || request.CmdName != kv.CmdWriteKey && request.CmdName != kv.CmdWriteBulk {
+		return next(ctx, request)
+	}
+
+	data := writeData(request)
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := s.Validate(key, data[key]); err != nil {
+			return kv.Response{}, err
+		}
+	}
+	return next(ctx, request)
+}
+
+// validationPushInterceptor reports pushed values that fail validation
+func (s *Client) validationPushInterceptor(push KeyValuePair, next PushHandler) {
+	if err := s.Validate(push.Key, push.Value); err != nil {
+		s.Logger.Warn("received invalid value", "key", push.Key, "error", err)
+		s.reportError(err)
+	}
+	next(push)
+}
+
+// Schema is a JSON Schema, of which the following keywords are supported:
+// type, enum, const, properties, required, additionalProperties (as a boolean),
+// items, minItems, maxItems, minimum, maximum, minLength, maxLength, pattern,
+// allOf and anyOf. Other keywords are ignored.
+type Schema struct {
+	Type                 SchemaTypes        `json:"type,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// SchemaTypes is the "type" keyword of a schema, which can be a single type or a list of them
+type SchemaTypes []string
+
+func (t *SchemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := jsoniter.ConfigFastest.Unmarshal(data, &single); err == nil {
+		*t = SchemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := jsoniter.ConfigFastest.Unmarshal(data, &list); err != nil {
+		return errors.New("type must be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+// ParseSchema parses a JSON Schema, see Schema for the supported keywords
+func ParseSchema(data []byte) (*Schema, error) {
+	var schema Schema
+	if err := jsoniter.ConfigFastest.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := schema.compile(); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return &schema, nil
+}
+
+// compile checks the schema and compiles its patterns
+func (s *Schema) compile() error {
+	for _, typ := range s.Type {
+		switch typ {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return fmt.Errorf("unknown type %q", typ)
+		}
+	}
+	if s.Pattern != "" {
+		var err error
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+	}
+
+	children := append(append([]*Schema{s.Items}, s.AllOf...), s.AnyOf...)
+	for _, child := range s.Properties {
+		children = append(children, child)
+	}
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		if err := child.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaError is a single violation of a schema
+type SchemaError struct {
+	Path    string // JSON Pointer to the invalid value
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Validate checks a JSON value against the schema, returning all the violations
+// (as *SchemaError) joined together
+func (s *Schema) Validate(value string) error {
+	var decoded interface{}
+	if err := jsoniter.ConfigFastest.UnmarshalFromString(value, &decoded); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return errors.Join(s.validate("", decoded)...)
+}
+
+func (s *Schema) validate(pointer string, value interface{}) []error {
+	fail := func(format string, args ...interface{}) []error {
+		return []error{&SchemaError{Path: pointer, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if len(s.Type) > 0 && !s.Type.match(value) {
+		return fail("expected %s, got %s", strings.Join(s.Type, " or "), jsonType(value))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if jsonEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("value is not one of the allowed values")
+		}
+	}
+	if s.Const != nil && !jsonEqual(value, s.Const) {
+		return fail("value is not the allowed value")
+	}
+
+	var errs []error
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, fail("missing required property %q", name)...)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := pointer + "/" + escapePointer(name)
+			if property, ok := s.Properties[name]; ok {
+				errs = append(errs, property.validate(child, v[name])...)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				errs = append(errs, &SchemaError{Path: child, Message: "property is not allowed"})
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			errs = append(errs, fail("expected at least %d items, got %d", *s.MinItems, len(v))...)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			errs = append(errs, fail("expected at most %d items, got %d", *s.MaxItems, len(v))...)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, s.Items.validate(pointer+"/"+strconv.Itoa(i), item)...)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			errs = append(errs, fail("%v is less than the minimum of %v", v, *s.Minimum)...)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			errs = append(errs, fail("%v is more than the maximum of %v", v, *s.Maximum)...)
+		}
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			errs = append(errs, fail("expected at least %d characters, got %d", *s.MinLength, length)...)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			errs = append(errs, fail("expected at most %d characters, got %d", *s.MaxLength, length)...)
+		}
+		if s.Pattern != "" {
+			pattern := s.pattern
+			if pattern == nil {
+				var err error
+				if pattern, err = regexp.Compile(s.Pattern); err != nil {
+					return fail("invalid pattern in schema: %s", err.Error())
+				}
+			}
+			if !pattern.MatchString(v) {
+				errs = append(errs, fail("value does not match pattern %q", s.Pattern)...)
+			}
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		errs = append(errs, sub.validate(pointer, value)...)
+	}
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, sub := range s.AnyOf {
+			if len(sub.validate(pointer, value)) < 1 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, fail("value does not match any of the allowed schemas")...)
+		}
+	}
+	return errs
+}
+
+func (t SchemaTypes) match(value interface{}) bool {
+	actual := jsonType(value)
+	for _, typ := range t {
+		if typ == actual || typ == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func jsonEqual(a, b interface{}) bool {
+	encodedA, errA := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(a)
+	encodedB, errB := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(b)
+	return errA == nil && errB == nil && string(encodedA) == string(encodedB)
+}
@@ -0,0 +1,176 @@
+package kvclient
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["user", "message"],
+	"additionalProperties": false,
+	"properties": {
+		"user": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+		"message": {"type": "string", "maxLength": 10},
+		"level": {"type": "integer", "minimum": 0, "maximum": 3},
+		"tags": {"type": "array", "maxItems": 2, "items": {"enum": ["mod", "vip"]}}
+	}
+}`
+
+func TestSchema(t *testing.T) {
+	schema, err := ParseSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatal("error parsing schema", err.Error())
+	}
+
+	if err := schema.Validate(`{"user":"ash","message":"hi","level":2,"tags":["vip"]}`); err != nil {
+		t.Fatal("valid value failed validation", err.Error())
+	}
+
+	err = schema.Validate(`{"user":"Ash","level":1.5,"tags":["vip","owner","mod"],"extra":true}`)
+	if err == nil {
+		t.Fatal("invalid value passed validation")
+	}
+	for _, expected := range []string{
+		`missing required property "message"`,
+		"/user: value does not match pattern",
+		"/level: expected integer, got number",
+		"/tags: expected at most 2 items",
+		"/tags/1: value is not one of the allowed values",
+		"/extra: property is not allowed",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("missing error %q in:\n%s", expected, err.Error())
+		}
+	}
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatal("errors are not SchemaErrors", err)
+	}
+
+	if err := schema.Validate(`not json`); err == nil {
+		t.Fatal("invalid JSON passed validation")
+	}
+
+	for _, invalid := range []string{`{"type":"thing"}`, `{"pattern":"("}`, `{"type":3}`} {
+		if _, err := ParseSchema([]byte(invalid)); err == nil {
+			t.Fatal("invalid schema was parsed", invalid)
+		}
+	}
+}
+
+func TestValidation(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	reported := make(chan error, 10)
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+		OnError: func(err error) {
+			reported <- err
+		},
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+	raw, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	schema, _ := ParseSchema([]byte(testSchema))
+	if err := client.RegisterValidator("chat/*/last", schema); err != nil {
+		t.Fatal("error registering validator", err.Error())
+	}
+	type settings struct {
+		Volume int `json:"volume"`
+	}
+	if err := client.RegisterValidator("settings", JSONValidator(func(s settings) error {
+		if s.Volume > 100 {
+			return errors.New("volume too high")
+		}
+		return nil
+	})); err != nil {
+		t.Fatal("error registering validator", err.Error())
+	}
+	if err := client.RegisterValidator("[", schema); err == nil {
+		t.Fatal("invalid pattern was registered")
+	}
+
+	chn, err := client.SubscribePrefix("chat/")
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+
+	if err := client.SetJSON("chat/ash/last", map[string]string{"user": "ash", "message": "hi"}); err != nil {
+		t.Fatal("valid write was rejected", err.Error())
+	}
+	err = client.SetJSON("chat/ash/last", map[string]string{"user": "ash"})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || !errors.Is(err, ErrInvalidValue) || validationErr.Key != "chat/ash/last" {
+		t.Fatal("expected a ValidationError, got", err)
+	}
+	if err := client.SetJSONs(map[string]interface{}{"settings": settings{Volume: 200}, "other": 1}); !errors.Is(err, ErrInvalidValue) {
+		t.Fatal("expected bulk write to be rejected, got", err)
+	}
+	if value, _ := raw.GetKey("other"); value != "" {
+		t.Fatal("rejected bulk write was partially applied")
+	}
+	// Keys not matching any pattern are not validated, and deleting is always allowed
+	if err := client.SetKey("chat/ash/other", "anything"); err != nil {
+		t.Fatal("unvalidated write was rejected", err.Error())
+	}
+	if err := client.SetKey("chat/ash/last", ""); err != nil {
+		t.Fatal("deletion was rejected", err.Error())
+	}
+
+	// Invalid values written by others are flagged
+	if err := raw.SetKey("chat/misty/last", `{"user":"misty"}`); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	var last map[string]string
+	if err := client.GetJSON("chat/misty/last", &last); !errors.Is(err, ErrInvalidValue) || last["user"] != "misty" {
+		t.Fatal("invalid read was not flagged", last, err)
+	}
+
+	// Invalid pushes are delivered, and reported to OnError
+	delivered := false
+	timeout := time.After(5 * time.Second)
+	for !delivered {
+		select {
+		case push := <-chn:
+			delivered = push.Key == "chat/misty/last"
+		case <-timeout:
+			t.Fatal("timed out waiting for push")
+		}
+	}
+	select {
+	case err := <-reported:
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Key != "chat/misty/last" {
+			t.Fatal("wrong error reported", err)
+		}
+	default:
+		t.Fatal("invalid push was not reported")
+	}
+	if len(reported) > 0 {
+		t.Fatal("valid push was reported", <-reported)
+	}
+
+	invalid, err := client.ValidatePrefix("chat/")
+	if err != nil {
+		t.Fatal("error validating prefix", err.Error())
+	}
+	if len(invalid) != 1 || invalid["chat/misty/last"] == nil {
+		t.Fatal("wrong invalid keys", invalid)
+	}
+
+	client.UnregisterValidator("chat/*/last")
+	if err := client.SetJSON("chat/ash/last", map[string]string{}); err != nil {
+		t.Fatal("write rejected after unregistering", err.Error())
+	}
+}
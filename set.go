@@ -0,0 +1,89 @@
+package kvclient
+
+import (
+	"sort"
+)
+
+// setMember is the value stored for members of a Set, removed members have an empty value
+const setMember = "1"
+
+// Set is an unordered set of strings stored one member per key under a prefix.
+//
+// Changes can be followed with Subscribe: pushes have the member as key and a
+// non-empty value if it was added, or an empty value if it was removed.
+type Set struct {
+	ns *Namespace
+}
+
+// Set returns the set stored under prefix
+func (s *Client) Set(prefix string) *Set {
+	return &Set{ns: s.Namespace(prefix)}
+}
+
+// Prefix returns the prefix the set is stored under
+func (s *Set) Prefix() string {
+	return s.ns.Prefix()
+}
+
+// Add adds members to the set
+func (s *Set) Add(members ...string) error {
+	return s.write(members, setMember)
+}
+
+// Remove removes members from the set
+func (s *Set) Remove(members ...string) error {
+	return s.write(members, "")
+}
+
+func (s *Set) write(members []string, value string) error {
+	if len(members) < 1 {
+		return nil
+	}
+	data := make(map[string]string, len(members))
+	for _, member := range members {
+		if member == "" {
+			return ErrEmptyKey
+		}
+		data[member] = value
+	}
+	return s.ns.SetKeys(data)
+}
+
+// Contains returns whether member is in the set
+func (s *Set) Contains(member string) (bool, error) {
+	value, err := s.ns.GetKey(member)
+	return value != "", err
+}
+
+// Members returns all members of the set, sorted
+func (s *Set) Members() ([]string, error) {
+	values, err := s.ns.GetByPrefix("")
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]string, 0, len(values))
+	for member, value := range values {
+		if value != "" {
+			members = append(members, member)
+		}
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// Len returns the number of members in the set
+func (s *Set) Len() (int, error) {
+	members, err := s.Members()
+	return len(members), err
+}
+
+// Subscribe returns a channel receiving changes to the set members
+func (s *Set) Subscribe() (chan KeyValuePair, error) {
+	return s.ns.SubscribePrefix("")
+}
+
+// Unsubscribe stops sending changes to a channel returned by Subscribe
+func (s *Set) Unsubscribe(chn chan KeyValuePair) error {
+	return s.ns.UnsubscribePrefix("", chn)
+}
@@ -0,0 +1,69 @@
+package kvclient
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSet(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	set := client.Set("followers/")
+	changes, err := set.Subscribe()
+	if err != nil {
+		t.Fatal("error subscribing", err.Error())
+	}
+	defer set.Unsubscribe(changes)
+
+	if err := set.Add("ash", "misty", "brock"); err != nil {
+		t.Fatal("error adding members", err.Error())
+	}
+	if err := set.Add("ash"); err != nil {
+		t.Fatal("error adding members", err.Error())
+	}
+	members, err := set.Members()
+	if err != nil {
+		t.Fatal("error getting members", err.Error())
+	}
+	if !reflect.DeepEqual(members, []string{"ash", "brock", "misty"}) {
+		t.Fatal("unexpected members", members)
+	}
+
+	if err := set.Remove("misty"); err != nil {
+		t.Fatal("error removing members", err.Error())
+	}
+	if ok, _ := set.Contains("misty"); ok {
+		t.Fatal("removed member is still in the set")
+	}
+	if ok, _ := set.Contains("ash"); !ok {
+		t.Fatal("member is missing from the set")
+	}
+	if n, _ := set.Len(); n != 2 {
+		t.Fatal("unexpected length", n)
+	}
+
+	// 3 added, "ash" added again, "misty" removed
+	received := make(map[string]string)
+	for i := 0; i < 5; i++ {
+		select {
+		case change := <-changes:
+			received[change.Key] = change.Value
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for change")
+		}
+	}
+	if received["misty"] != "" || received["ash"] == "" || received["brock"] == "" {
+		t.Fatal("unexpected changes", received)
+	}
+}
@@ -0,0 +1,224 @@
+package kvclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	kv "github.com/strimertul/kilovolt/v11"
+)
+
+// Snapshots are NDJSON files: an optional SnapshotHeader line followed by one
+// SnapshotEntry line per key, sorted by key. For example:
+//
+//	{"kilovolt_snapshot":1,"server_version":"v11","created_at":"2023-10-01T12:00:00Z","prefix":"twitch/"}
+//	{"key":"twitch/config","value":"{\"enabled\":true}"}
+//	{"key":"twitch/ev/chat-message","value":"hello"}
+
+// SnapshotFormatVersion is the version of the snapshot format written by Export
+const SnapshotFormatVersion = 1
+
+var ErrUnsupportedSnapshot = errors.New("unsupported snapshot format version")
+
+// SnapshotHeader is the first line of an exported snapshot
+type SnapshotHeader struct {
+	Format        int       `json:"kilovolt_snapshot"`
+	ServerVersion string    `json:"server_version,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	Prefix        string    `json:"prefix"`
+}
+
+// SnapshotEntry is a single key in a snapshot
+type SnapshotEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// How many keys to read or write in a single request when exporting/importing
+const defaultSnapshotChunkSize = 100
+
+// ServerVersion returns the protocol version reported by the server
+func (s *Client) ServerVersion() (string, error) {
+	resp, err := s.makeRequest(kv.Request{
+		CmdName: kv.CmdProtoVersion,
+	})
+	if err != nil {
+		return "", err
+	}
+	version, _ := resp.Data.(string)
+	return version, nil
+}
+
+// Export writes all keys starting with prefix to w as a snapshot
+func (s *Client) Export(prefix string, w io.Writer) error {
+	keys, err := s.ListKeys(prefix)
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+
+	// Not all servers support this, the version is informative anyway
+	version, _ := s.ServerVersion()
+
+	out := bufio.NewWriter(w)
+	encoder := jsoniter.ConfigFastest.NewEncoder(out)
+	if err := encoder.Encode(SnapshotHeader{
+		Format:        SnapshotFormatVersion,
+		ServerVersion: version,
+		CreatedAt:     time.Now().UTC(),
+		Prefix:        prefix,
+	}); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(keys); start += defaultSnapshotChunkSize {
+		chunk := keys[start:min(start+defaultSnapshotChunkSize, len(keys))]
+		values, err := s.GetKeys(chunk)
+		if err != nil {
+			return err
+		}
+		for _, key := range chunk {
+			if err := encoder.Encode(SnapshotEntry{Key: key, Value: values[key]}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return out.Flush()
+}
+
+// ImportPolicy decides what to do with keys that already have a value
+type ImportPolicy int
+
+const (
+	// ImportOverwrite replaces existing values
+	ImportOverwrite ImportPolicy = iota
+	// ImportSkipExisting leaves keys that already have a (non-empty) value untouched
+	ImportSkipExisting
+)
+
+// ImportOptions configures Import
+type ImportOptions struct {
+	Policy ImportPolicy
+
+	// DryRun goes through the whole snapshot, reporting progress, without writing anything
+	DryRun bool
+
+	// ChunkSize is how many keys to write with a single SetKeys call, defaults to 100
+	ChunkSize int
+
+	// Progress, if set, is called after every chunk
+	Progress func(ImportProgress)
+}
+
+// ImportProgress reports how far an import has gone
+type ImportProgress struct {
+	Header  *SnapshotHeader // nil if the snapshot has no header
+	Read    int             // Keys read from the snapshot
+	Written int             // Keys written (or that would have been written, in dry runs)
+	Skipped int             // Keys skipped because of ImportSkipExisting
+}
+
+// Import restores keys from a snapshot written by Export
+func (s *Client) Import(r io.Reader, options ImportOptions) (ImportProgress, error) {
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = defaultSnapshotChunkSize
+	}
+
+	var progress ImportProgress
+	chunk := make(map[string]string)
+
+	flush := func() error {
+		if len(chunk) < 1 {
+			return nil
+		}
+
+		if options.Policy == ImportSkipExisting {
+			keys := make([]string, 0, len(chunk))
+			for key := range chunk {
+				keys = append(keys, key)
+			}
+			existing, err := s.GetKeys(keys)
+			if err != nil {
+				return err
+			}
+			for key, value := range existing {
+				if value != "" {
+					delete(chunk, key)
+					progress.Skipped++
+				}
+			}
+		}
+
+		if !options.DryRun && len(chunk) > 0 {
+			if err := s.SetKeys(chunk); err != nil {
+				return err
+			}
+		}
+		progress.Written += len(chunk)
+		chunk = make(map[string]string)
+
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+		return nil
+	}
+
+	err := readSnapshot(r, func(header *SnapshotHeader) {
+		progress.Header = header
+	}, func(entry SnapshotEntry) error {
+		chunk[entry.Key] = entry.Value
+		progress.Read++
+		if len(chunk) >= options.ChunkSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return progress, err
+	}
+
+	return progress, flush()
+}
+
+// readSnapshot parses a snapshot, calling onHeader if it has a header line and onEntry for every key
+func readSnapshot(r io.Reader, onHeader func(*SnapshotHeader), onEntry func(SnapshotEntry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) < 1 {
+			continue
+		}
+
+		// The first line might be a header
+		if line == 1 {
+			var header SnapshotHeader
+			if err := jsoniter.ConfigFastest.Unmarshal(scanner.Bytes(), &header); err == nil && header.Format != 0 {
+				if header.Format > SnapshotFormatVersion {
+					return fmt.Errorf("%w: %d", ErrUnsupportedSnapshot, header.Format)
+				}
+				onHeader(&header)
+				continue
+			}
+		}
+
+		var entry SnapshotEntry
+		if err := jsoniter.ConfigFastest.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("invalid snapshot entry on line %d: %w", line, err)
+		}
+		if entry.Key == "" {
+			return fmt.Errorf("invalid snapshot entry on line %d: missing key", line)
+		}
+
+		if err := onEntry(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
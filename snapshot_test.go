@@ -0,0 +1,153 @@
+package kvclient
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestSnapshot(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	data := map[string]string{
+		"snap/a":     "first",
+		"snap/b":     `{"json":true}`,
+		"snap/c/d":   "nested\nvalue",
+		"other/skip": "not exported",
+	}
+	if err := client.SetKeys(data); err != nil {
+		t.Fatal("error setting keys", err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := client.Export("snap/", &buf); err != nil {
+		t.Fatal("error exporting keys", err.Error())
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected header and 3 entries, got %d lines:\n%s", len(lines), buf.String())
+	}
+	snapshot := buf.String()
+
+	t.Run("Import into empty server", func(t *testing.T) {
+		server, _ := createInMemoryKV(t, log)
+		target, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+		if err != nil {
+			t.Fatal("error creating kv client", err.Error())
+		}
+
+		var calls int
+		progress, err := target.Import(strings.NewReader(snapshot), ImportOptions{
+			ChunkSize: 2,
+			Progress:  func(ImportProgress) { calls++ },
+		})
+		if err != nil {
+			t.Fatal("error importing snapshot", err.Error())
+		}
+		if progress.Header == nil || progress.Header.Prefix != "snap/" || progress.Header.Format != SnapshotFormatVersion {
+			t.Fatalf("unexpected header: %+v", progress.Header)
+		}
+		if progress.Read != 3 || progress.Written != 3 || calls != 2 {
+			t.Fatalf("unexpected progress: %+v (%d calls)", progress, calls)
+		}
+
+		for _, key := range []string{"snap/a", "snap/b", "snap/c/d"} {
+			val, err := target.GetKey(key)
+			if err != nil {
+				t.Fatal("error getting key", err.Error())
+			}
+			if val != data[key] {
+				t.Fatalf("imported value for %s is different than expected, expected=%s got=%s", key, data[key], val)
+			}
+		}
+		val, _ := target.GetKey("other/skip")
+		if val != "" {
+			t.Fatal("key outside of prefix was imported")
+		}
+	})
+
+	t.Run("Dry run", func(t *testing.T) {
+		server, _ := createInMemoryKV(t, log)
+		target, err := NewClient(server.URL, ClientOptions{Logger: ZapLogger(log)})
+		if err != nil {
+			t.Fatal("error creating kv client", err.Error())
+		}
+
+		progress, err := target.Import(strings.NewReader(snapshot), ImportOptions{DryRun: true})
+		if err != nil {
+			t.Fatal("error importing snapshot", err.Error())
+		}
+		if progress.Written != 3 {
+			t.Fatalf("unexpected progress: %+v", progress)
+		}
+		keys, err := target.ListKeys("snap/")
+		if err != nil {
+			t.Fatal("error listing keys", err.Error())
+		}
+		if len(keys) != 0 {
+			t.Fatal("dry run wrote keys", keys)
+		}
+	})
+
+	t.Run("Skip existing", func(t *testing.T) {
+		if err := client.SetKey("snap/a", "changed"); err != nil {
+			t.Fatal("error setting key", err.Error())
+		}
+		progress, err := client.Import(strings.NewReader(snapshot), ImportOptions{Policy: ImportSkipExisting})
+		if err != nil {
+			t.Fatal("error importing snapshot", err.Error())
+		}
+		if progress.Skipped != 3 || progress.Written != 0 {
+			t.Fatalf("unexpected progress: %+v", progress)
+		}
+		val, _ := client.GetKey("snap/a")
+		if val != "changed" {
+			t.Fatal("existing key was overwritten", val)
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		if _, err := client.Import(strings.NewReader(snapshot), ImportOptions{}); err != nil {
+			t.Fatal("error importing snapshot", err.Error())
+		}
+		val, _ := client.GetKey("snap/a")
+		if val != "first" {
+			t.Fatal("existing key was not overwritten", val)
+		}
+	})
+
+	t.Run("Without header", func(t *testing.T) {
+		progress, err := client.Import(strings.NewReader(`{"key":"snap/raw","value":"1"}`+"\n"), ImportOptions{})
+		if err != nil {
+			t.Fatal("error importing snapshot", err.Error())
+		}
+		if progress.Header != nil || progress.Written != 1 {
+			t.Fatalf("unexpected progress: %+v", progress)
+		}
+	})
+
+	t.Run("Unsupported version", func(t *testing.T) {
+		_, err := client.Import(strings.NewReader(`{"kilovolt_snapshot":99}`+"\n"), ImportOptions{})
+		if !errors.Is(err, ErrUnsupportedSnapshot) {
+			t.Fatal("expected ErrUnsupportedSnapshot, got", err)
+		}
+	})
+
+	t.Run("Invalid entry", func(t *testing.T) {
+		_, err := client.Import(strings.NewReader(snapshot+"not json\n"), ImportOptions{DryRun: true})
+		if err == nil || !strings.Contains(err.Error(), "line 5") {
+			t.Fatal("expected error on line 5, got", err)
+		}
+	})
+}
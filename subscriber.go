@@ -0,0 +1,74 @@
+package kvclient
+
+import (
+	cmap "github.com/orcaman/concurrent-map"
+)
+
+// subscriber is a channel receiving pushes for a key or prefix subscription
+type subscriber struct {
+	ch chan KeyValuePair
+
+	// filter, if set, can rewrite a push before it's delivered, or drop it by returning false
+	filter func(KeyValuePair) (KeyValuePair, bool)
+
+	// close, if set, is called when the subscriber is removed
+	close func()
+}
+
+// addSubscriber adds a subscriber to the list for the given key, returning
+// true if it's the first one (and the server needs to be told to send pushes)
+func addSubscriber(subs cmap.ConcurrentMap, key string, sub *subscriber) bool {
+	first := false
+	subs.Upsert(key, nil, func(exist bool, current interface{}, _ interface{}) interface{} {
+		var list []*subscriber
+		if exist {
+			list = current.([]*subscriber)
+		}
+		first = len(list) < 1
+		// Always copy, the read loop may be iterating over the current slice
+		return append(append(make([]*subscriber, 0, len(list)+1), list...), sub)
+	})
+	return first
+}
+
+// removeSubscriber removes the subscriber using chn from the list for the given key,
+// returning whether it was found and whether the list is now empty
+func removeSubscriber(subs cmap.ConcurrentMap, key string, chn chan KeyValuePair) (found bool, empty bool) {
+	subs.Upsert(key, nil, func(exist bool, current interface{}, _ interface{}) interface{} {
+		var list []*subscriber
+		if exist {
+			list = current.([]*subscriber)
+		}
+		updated := make([]*subscriber, 0, len(list))
+		for _, sub := range list {
+			if sub.ch == chn {
+				found = true
+				if sub.close != nil {
+					sub.close()
+				}
+				continue
+			}
+			updated = append(updated, sub)
+		}
+		empty = len(updated) < 1
+		return updated
+	})
+	return found, found && empty
+}
+
+// unsubscribeDraining calls unsubscribe while discarding pushes delivered to chn, so
+// that a full channel can't block the read loop before the server stops pushing
+func unsubscribeDraining(chn chan KeyValuePair, unsubscribe func() error) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-chn:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return unsubscribe()
+}
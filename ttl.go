@@ -0,0 +1,220 @@
+package kvclient
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// DefaultTTLPrefix is the prefix under which expiry times are stored
+const DefaultTTLPrefix = "_ttl/"
+
+// TTLOptions configures a TTLStore
+type TTLOptions struct {
+	// Prefix for the companion keys holding expiry times, defaults to DefaultTTLPrefix.
+	// The expiry time of "key" is stored in Prefix+"key".
+	Prefix string
+
+	// Clock used to tell time, defaults to SystemClock
+	Clock Clock
+
+	// JanitorInterval is how often expired keys are removed, defaults to a minute.
+	// A negative interval disables the janitor, expired keys are then only removed by Sweep.
+	JanitorInterval time.Duration
+
+	// OnError is called when the janitor fails to remove expired keys
+	OnError func(error)
+}
+
+// TTLStore reads and writes keys that expire after some time.
+//
+// Kilovolt has no expiry, so the expiry time of each key is kept in a companion key
+// and a janitor periodically removes expired keys by setting them (and their companion)
+// to an empty value. Reads through the store treat expired keys as missing even if the
+// janitor hasn't removed them yet, reads that bypass the store do not.
+type TTLStore struct {
+	client  *Client
+	options TTLOptions
+
+	mu     sync.Mutex
+	timer  Timer
+	closed bool
+}
+
+// TTL returns a store for expiring keys and starts its janitor
+func (s *Client) TTL(options TTLOptions) *TTLStore {
+	if options.Prefix == "" {
+		options.Prefix = DefaultTTLPrefix
+	}
+	if options.Clock == nil {
+		options.Clock = SystemClock
+	}
+	if options.JanitorInterval == 0 {
+		options.JanitorInterval = time.Minute
+	}
+
+	store := &TTLStore{
+		client:  s,
+		options: options,
+	}
+	if options.JanitorInterval > 0 {
+		store.mu.Lock()
+		store.timer = options.Clock.AfterFunc(options.JanitorInterval, store.janitor)
+		store.mu.Unlock()
+	}
+	return store
+}
+
+// Close stops the janitor
+func (t *TTLStore) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	return nil
+}
+
+func (t *TTLStore) janitor() {
+	if _, err := t.Sweep(); err != nil {
+		t.client.Logger.Warn("could not remove expired keys", "error", err)
+		if t.options.OnError != nil {
+			t.options.OnError(err)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.timer = t.options.Clock.AfterFunc(t.options.JanitorInterval, t.janitor)
+	}
+}
+
+func (t *TTLStore) metaKey(key string) string {
+	return t.options.Prefix + key
+}
+
+func (t *TTLStore) expired(expiry string, now time.Time) bool {
+	if expiry == "" {
+		return false
+	}
+	at, err := time.Parse(time.RFC3339Nano, expiry)
+	// Unreadable expiry times are treated as expired rather than kept forever
+	return err != nil || !now.Before(at)
+}
+
+// SetWithTTL writes a key that expires after d
+func (t *TTLStore) SetWithTTL(key string, value string, d time.Duration) error {
+	return t.client.SetKeys(map[string]string{
+		key:            value,
+		t.metaKey(key): t.options.Clock.Now().Add(d).UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// SetJSONWithTTL writes a key encoded as JSON that expires after d
+func (t *TTLStore) SetJSONWithTTL(key string, data interface{}, d time.Duration) error {
+	value, err := jsoniter.ConfigFastest.MarshalToString(data)
+	if err != nil {
+		return err
+	}
+	return t.SetWithTTL(key, value, d)
+}
+
+// Set writes a key that never expires, removing any expiry it had
+func (t *TTLStore) Set(key string, value string) error {
+	return t.client.SetKeys(map[string]string{
+		key:            value,
+		t.metaKey(key): "",
+	})
+}
+
+// Delete removes a key and its expiry
+func (t *TTLStore) Delete(key string) error {
+	return t.Set(key, "")
+}
+
+// Get reads a key, returning an empty value if it expired
+func (t *TTLStore) Get(key string) (string, error) {
+	values, err := t.client.GetKeys([]string{key, t.metaKey(key)})
+	if err != nil {
+		return "", err
+	}
+	if t.expired(values[t.metaKey(key)], t.options.Clock.Now()) {
+		return "", nil
+	}
+	return values[key], nil
+}
+
+// GetJSON reads a key encoded as JSON, returning ErrEmptyKey if it's empty or expired
+func (t *TTLStore) GetJSON(key string, dst interface{}) error {
+	value, err := t.Get(key)
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		return ErrEmptyKey
+	}
+	return jsoniter.ConfigFastest.UnmarshalFromString(value, dst)
+}
+
+// TTL returns how long until a key expires, or false if it has no expiry
+func (t *TTLStore) TTL(key string) (time.Duration, bool, error) {
+	expiry, err := t.client.GetKey(t.metaKey(key))
+	if err != nil || expiry == "" {
+		return 0, false, err
+	}
+	at, err := time.Parse(time.RFC3339Nano, expiry)
+	if err != nil {
+		return 0, true, nil
+	}
+	return max(at.Sub(t.options.Clock.Now()), 0), true, nil
+}
+
+// Sweep removes all expired keys, returning how many were removed.
+// Keys are removed with a single write, so on error none were.
+func (t *TTLStore) Sweep() (int, error) {
+	expiries, err := t.client.GetByPrefix(t.options.Prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	now := t.options.Clock.Now()
+	var keys []string
+	for meta, expiry := range expiries {
+		if expiry != "" && t.expired(expiry, now) {
+			keys = append(keys, strings.TrimPrefix(meta, t.options.Prefix))
+		}
+	}
+	if len(keys) < 1 {
+		return 0, nil
+	}
+
+	// Check again right before removing, in case someone renewed a key in the meantime.
+	// This narrows the window but can't close it without atomic operations.
+	toCheck := make([]string, 0, len(keys))
+	for _, key := range keys {
+		toCheck = append(toCheck, t.metaKey(key))
+	}
+	current, err := t.client.GetKeys(toCheck)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := make(map[string]string)
+	for _, key := range keys {
+		if expiry := current[t.metaKey(key)]; expiry != "" && t.expired(expiry, now) {
+			removed[key] = ""
+			removed[t.metaKey(key)] = ""
+		}
+	}
+	if len(removed) < 1 {
+		return 0, nil
+	}
+	if err := t.client.SetKeys(removed); err != nil {
+		return 0, err
+	}
+	return len(removed) / 2, nil
+}
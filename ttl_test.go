@@ -0,0 +1,123 @@
+package kvclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestTTL(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	server, _ := createInMemoryKV(t, log)
+
+	client, err := NewClient(server.URL, ClientOptions{
+		Logger: ZapLogger(log),
+	})
+	if err != nil {
+		t.Fatal("error creating kv client", err.Error())
+	}
+
+	clock := newFakeClock()
+	store := client.TTL(TTLOptions{
+		Clock:           clock,
+		JanitorInterval: time.Minute,
+	})
+	defer store.Close()
+
+	if err := store.SetWithTTL("cooldown/ash", "1", 30*time.Second); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	if err := store.SetJSONWithTTL("session/ash", map[string]string{"token": "abc"}, 90*time.Second); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	if err := store.Set("config", "forever"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+
+	get := func(key string) string {
+		t.Helper()
+		value, err := store.Get(key)
+		if err != nil {
+			t.Fatal("error getting key", err.Error())
+		}
+		return value
+	}
+
+	if get("cooldown/ash") != "1" {
+		t.Fatal("key is missing before expiring")
+	}
+	if remaining, ok, _ := store.TTL("cooldown/ash"); !ok || remaining != 30*time.Second {
+		t.Fatal("unexpected TTL", remaining, ok)
+	}
+	if _, ok, _ := store.TTL("config"); ok {
+		t.Fatal("key without TTL has an expiry")
+	}
+
+	// Expired keys are missing from reads before the janitor runs
+	clock.Advance(45 * time.Second)
+	if get("cooldown/ash") != "" {
+		t.Fatal("expired key was returned")
+	}
+	if raw, _ := client.GetKey("cooldown/ash"); raw != "1" {
+		t.Fatal("key was removed before the janitor ran", raw)
+	}
+	var session map[string]string
+	if err := store.GetJSON("session/ash", &session); err != nil || session["token"] != "abc" {
+		t.Fatal("unexpected session", session, err)
+	}
+
+	// The janitor runs at one minute
+	clock.Advance(15 * time.Second)
+	if raw, _ := client.GetKey("cooldown/ash"); raw != "" {
+		t.Fatal("janitor did not remove expired key", raw)
+	}
+	if raw, _ := client.GetKey(DefaultTTLPrefix + "cooldown/ash"); raw != "" {
+		t.Fatal("janitor did not remove expiry", raw)
+	}
+	if raw, _ := client.GetKey("session/ash"); raw == "" {
+		t.Fatal("janitor removed a key that has not expired")
+	}
+
+	// Renewing a key pushes its expiry forward
+	if err := store.SetWithTTL("session/ash", `{"token":"def"}`, 90*time.Second); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	clock.Advance(60 * time.Second)
+	if err := store.GetJSON("session/ash", &session); err != nil || session["token"] != "def" {
+		t.Fatal("renewed key expired", session, err)
+	}
+	clock.Advance(60 * time.Second)
+	if err := store.GetJSON("session/ash", &session); !errors.Is(err, ErrEmptyKey) {
+		t.Fatal("expected ErrEmptyKey, got", err)
+	}
+
+	// Setting without TTL clears the expiry
+	if err := store.SetWithTTL("cooldown/misty", "1", time.Second); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	if err := store.Set("cooldown/misty", "persistent"); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	clock.Advance(time.Hour)
+	if get("cooldown/misty") != "persistent" || get("config") != "forever" {
+		t.Fatal("keys without TTL expired")
+	}
+
+	// No more sweeps after closing
+	if err := store.Close(); err != nil {
+		t.Fatal("error closing store", err.Error())
+	}
+	if err := store.SetWithTTL("cooldown/brock", "1", time.Second); err != nil {
+		t.Fatal("error setting key", err.Error())
+	}
+	clock.Advance(time.Hour)
+	if raw, _ := client.GetKey("cooldown/brock"); raw != "1" {
+		t.Fatal("janitor ran after closing", raw)
+	}
+	removed, err := store.Sweep()
+	if err != nil || removed != 1 {
+		t.Fatal("unexpected sweep result", removed, err)
+	}
+}